@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/bardic/Dirk/internal/dagger"
+)
+
+// Fields of an assembly definition that tell test assemblies apart
+type asmdef struct {
+	References              []string `json:"references"`
+	IncludePlatforms        []string `json:"includePlatforms"`
+	OptionalUnityReferences []string `json:"optionalUnityReferences"`
+	PrecompiledReferences   []string `json:"precompiledReferences"`
+}
+
+// References to the Unity Test Framework, by name or by GUID
+var testRunnerReferences = []string{
+	"UnityEngine.TestRunner",
+	"UnityEditor.TestRunner",
+	"GUID:27619889b8ba8c24980f49ee34dbb44a",
+	"GUID:0acc523941302664db1f4e527237feb3",
+}
+
+// isTestAssembly reports whether the assembly references the test framework
+func (a asmdef) isTestAssembly() bool {
+	for _, ref := range a.References {
+		if slices.Contains(testRunnerReferences, ref) {
+			return true
+		}
+	}
+
+	// Assemblies created before the Test Framework package opt in this way
+	return slices.Contains(a.OptionalUnityReferences, "TestAssemblies") ||
+		slices.Contains(a.PrecompiledReferences, "nunit.framework.dll")
+}
+
+// TestPlatforms returns the testing platforms, EditMode and PlayMode, that have
+// tests in the project
+//
+// Test assemblies are found by scanning Assets for assembly definitions that
+// reference the Unity Test Framework. Editor-only assemblies hold EditMode
+// tests, any other PlayMode tests.
+func (d *Dirk) TestPlatforms(ctx context.Context, src *dagger.Directory) ([]string, error) {
+	paths, err := src.Glob(ctx, "Assets/**/*.asmdef")
+
+	if err != nil {
+		return nil, fmt.Errorf("could not list assembly definitions: %w", err)
+	}
+
+	var editMode, playMode bool
+
+	for _, p := range paths {
+		contents, err := src.File(p).Contents(ctx)
+
+		if err != nil {
+			return nil, fmt.Errorf("could not read %s: %w", p, err)
+		}
+
+		var a asmdef
+
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(contents, "\ufeff")), &a); err != nil {
+			return nil, fmt.Errorf("could not parse %s: %w", p, err)
+		}
+
+		if !a.isTestAssembly() {
+			continue
+		}
+
+		if len(a.IncludePlatforms) == 1 && a.IncludePlatforms[0] == "Editor" {
+			editMode = true
+		} else {
+			playMode = true
+		}
+	}
+
+	platforms := []string{}
+
+	if editMode {
+		platforms = append(platforms, "EditMode")
+	}
+
+	if playMode {
+		platforms = append(platforms, "PlayMode")
+	}
+
+	return platforms, nil
+}
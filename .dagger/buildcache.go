@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bardic/Dirk/internal/dagger"
+)
+
+// Cache volume holding finished builds, one directory per build key
+const buildCacheVolume = "dirk-builds"
+
+// buildCacheKey hashes the source and every setting that changes the build
+// output or whether it passes, so identical builds share a key. A setting
+// left out here makes a cache hit return a build made with another value, so
+// every build setting passed to the editor as an env var or file, or used to
+// check the build, must be hashed here.
+func (d *Dirk) buildCacheKey(ctx context.Context) (string, error) {
+	digest, err := d.Src.Digest(ctx)
+
+	if err != nil {
+		return "", fmt.Errorf("could not hash the source: %w", err)
+	}
+
+	h := sha256.New()
+
+	fmt.Fprintf(h, "src=%s\n", digest)
+
+	// Secrets such as the keystore and certificate passwords are left out so
+	// they never end up in a key
+	for _, file := range []struct {
+		name string
+		file *dagger.File
+	}{
+		{"preBuildScript", d.PreBuildScript},
+		{"postBuildScript", d.PostBuildScript},
+		{"playerSettings", d.PlayerSettings},
+		{"keystore", d.Keystore},
+		{"signingCert", d.SigningCert},
+	} {
+		if file.file == nil {
+			continue
+		}
+
+		fileDigest, err := dag.Directory().WithFile("file", file.file).Digest(ctx)
+
+		if err != nil {
+			return "", fmt.Errorf("could not hash the %s: %w", file.name, err)
+		}
+
+		fmt.Fprintf(h, "%s=%s\n", file.name, fileDigest)
+	}
+
+	fmt.Fprintf(h, "unityVersion=%s\n", d.UnityVersion)
+	fmt.Fprintf(h, "gameciVersion=%s\n", d.GameciVersion)
+	fmt.Fprintf(h, "os=%s\n", d.Os)
+	fmt.Fprintf(h, "registry=%s\n", d.Registry)
+	fmt.Fprintf(h, "platformArch=%s\n", d.PlatformArch)
+	fmt.Fprintf(h, "platform=%s\n", d.Platform)
+	fmt.Fprintf(h, "buildTarget=%s\n", d.BuildTarget)
+	fmt.Fprintf(h, "architecture=%s\n", d.Architecture)
+	fmt.Fprintf(h, "buildMethod=%s\n", d.BuildMethod)
+	fmt.Fprintf(h, "buildName=%s\n", d.BuildName)
+	fmt.Fprintf(h, "buildNumber=%d\n", d.BuildNumber)
+	fmt.Fprintf(h, "bundleVersion=%s\n", d.BundleVersion)
+	fmt.Fprintf(h, "defines=%s\n", strings.Join(d.Defines, ","))
+	fmt.Fprintf(h, "deterministic=%t\n", d.Deterministic)
+	fmt.Fprintf(h, "development=%t\n", d.Development)
+	fmt.Fprintf(h, "fast=%t\n", d.Fast)
+	fmt.Fprintf(h, "scriptingBackend=%s\n", d.ScriptingBackend)
+	fmt.Fprintf(h, "il2cppArgs=%s\n", strings.Join(d.Il2cppArgs, " "))
+	fmt.Fprintf(h, "serverBuild=%t\n", d.ServerBuild)
+	fmt.Fprintf(h, "androidAppBundle=%t\n", d.AndroidAppBundle)
+	fmt.Fprintf(h, "keystoreAlias=%s\n", d.KeystoreAlias)
+	fmt.Fprintf(h, "textureCompression=%s\n", d.TextureCompression)
+	fmt.Fprintf(h, "webglCompression=%s\n", d.WebglCompression)
+	fmt.Fprintf(h, "buildAddressables=%t\n", d.BuildAddressables)
+	fmt.Fprintf(h, "buildMetrics=%t\n", d.BuildMetrics)
+	fmt.Fprintf(h, "maxBuildSize=%d\n", d.MaxBuildSize)
+	fmt.Fprintf(h, "outputLayout=%s\n", d.OutputLayout)
+	fmt.Fprintf(h, "warningsAsErrors=%t\n", d.WarningsAsErrors)
+	fmt.Fprintf(h, "signingIdentity=%s\n", d.SigningIdentity)
+	fmt.Fprintf(h, "bootScene=%s\n", d.BootScene)
+	fmt.Fprintf(h, "scenes=%s\n", strings.Join(d.Scenes, ","))
+	fmt.Fprintf(h, "extraArgs=%s\n", strings.Join(d.ExtraArgs, " "))
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// buildCacheContainer mounts the build cache volume at /cache
+func (d *Dirk) buildCacheContainer() *dagger.Container {
+	return dag.Container().From("alpine").
+		WithMountedCache("/cache", dag.CacheVolume(buildCacheVolume))
+}
+
+// cachedBuild returns the build stored under key, unless a rebuild is forced
+func (d *Dirk) cachedBuild(ctx context.Context, key string) (*dagger.Directory, bool) {
+	if d.ForceRebuild {
+		fmt.Println("Rebuild forced, skipping the build cache")
+		return nil, false
+	}
+
+	c := d.buildCacheContainer().
+		// The volume changes outside of Dagger's view, so the lookup must never
+		// be served from Dagger's own cache
+		WithEnvVariable("DIRK_BUILD_CACHE_LOOKUP", time.Now().String()).
+		WithExec([]string{
+			"sh",
+			"-c",
+			"mkdir -p /out && test -d /cache/" + key + " && cp -a /cache/" + key + "/. /out/",
+		}, dagger.ContainerWithExecOpts{
+			Expect: dagger.ReturnTypeAny,
+		})
+
+	exitCode, err := c.ExitCode(ctx)
+
+	if err != nil || exitCode != 0 {
+		fmt.Println("No cached build for " + key)
+		return nil, false
+	}
+
+	fmt.Println("Using cached build " + key)
+
+	return c.Directory("/out"), true
+}
+
+// storeBuild saves the build under key, replacing any previous one atomically
+func (d *Dirk) storeBuild(ctx context.Context, key string, build *dagger.Directory) error {
+	_, err := d.buildCacheContainer().
+		WithDirectory("/build", build).
+		WithExec([]string{
+			"sh",
+			"-c",
+			"rm -rf /cache/" + key + ".tmp && cp -a /build /cache/" + key + ".tmp && rm -rf /cache/" + key + " && mv /cache/" + key + ".tmp /cache/" + key,
+		}).
+		Sync(ctx)
+
+	if err != nil {
+		return fmt.Errorf("could not store the build in the build cache: %w", err)
+	}
+
+	fmt.Println("Stored build " + key)
+
+	return nil
+}
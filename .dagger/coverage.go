@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/bardic/Dirk/internal/dagger"
+)
+
+// Coverage summary written by the Code Coverage package as Summary.xml
+type coverageSummaryXML struct {
+	Summary struct {
+		Linecoverage float64 `xml:"Linecoverage"`
+	} `xml:"Summary"`
+}
+
+// Coverage summary written by the Code Coverage package as Summary.json
+type coverageSummaryJSON struct {
+	Summary struct {
+		Linecoverage float64 `json:"linecoverage"`
+	} `json:"summary"`
+}
+
+func (d *Dirk) coverageResultsPath() string {
+	if d.CoverageResultsPath != "" {
+		return "/results/" + path.Clean(d.CoverageResultsPath) + "/"
+	}
+
+	return "/results/" + d.TestingingPlatform + "-coverage/"
+}
+
+// coverageHistoryPath is where the HTML report history accumulates. It is
+// returned with the results so callers can pass it back as coverageHistory.
+func (d *Dirk) coverageHistoryPath() string {
+	if d.CoverageHistoryPath != "" {
+		return "/results/" + path.Clean(d.CoverageHistoryPath) + "/"
+	}
+
+	return "/results/" + d.TestingingPlatform + "-coverage-history/"
+}
+
+// checkResultsSubpath fails unless p stays inside /results, so whatever is
+// written there is returned with the results
+func checkResultsSubpath(name string, p string) error {
+	if p == "" {
+		return nil
+	}
+
+	clean := path.Clean(p)
+
+	if path.IsAbs(clean) || clean == "." || clean == ".." || strings.HasPrefix(clean, "../") {
+		return fmt.Errorf("invalid %s %q: expected a relative path inside /results", name, p)
+	}
+
+	return nil
+}
+
+// Unity Code Coverage verbosity for each supported coverageVerbosity
+var coverageVerbosities = map[string]string{
+	"minimal": "warning",
+	"normal":  "info",
+	"verbose": "verbose",
+}
+
+// coverageOptions builds the -coverageOptions argument passed to Unity
+func (d *Dirk) coverageOptions() string {
+	var options []string
+
+	if d.CoverageAdditionalMetrics {
+		options = append(options, "generateAdditionalMetrics")
+	}
+
+	if d.CoverageHtmlReport {
+		options = append(options, "generateHtmlReport")
+	}
+
+	if d.CoverageHtmlReportHistory {
+		options = append(options, "generateHtmlReportHistory")
+	}
+
+	if d.CoverageBadgeReport {
+		options = append(options, "generateBadgeReport")
+	}
+
+	if d.Cobertura {
+		options = append(options, "generateAdditionalReports")
+	}
+
+	if d.CoverageAssemblyFilters != "" {
+		options = append(options, "assemblyFilters:"+d.CoverageAssemblyFilters)
+	}
+
+	if d.CoveragePathFilters != "" {
+		options = append(options, "pathFilters:"+d.CoveragePathFilters)
+	}
+
+	verbosity := "verbose"
+
+	if d.CoverageVerbosity != "" {
+		verbosity = coverageVerbosities[d.CoverageVerbosity]
+	}
+
+	options = append(options, "verbosity:"+verbosity)
+
+	return "'" + strings.Join(options, ";") + "'"
+}
+
+// convertCoverageToCobertura returns the Cobertura report, as read by Codecov,
+// that ReportGenerator writes next to the summary when additional reports
+// are enabled
+func (d *Dirk) convertCoverageToCobertura(c *dagger.Container) *dagger.File {
+	return c.File(d.coverageResultsPath() + "Report/Cobertura.xml")
+}
+
+// lineCoverage reads the line coverage percentage from the coverage summary
+func (d *Dirk) lineCoverage(ctx context.Context, c *dagger.Container) (float64, error) {
+	report := d.coverageResultsPath() + "Report/"
+
+	if s, err := c.File(report + "Summary.xml").Contents(ctx); err == nil {
+		var summary coverageSummaryXML
+
+		if err := xml.Unmarshal([]byte(s), &summary); err != nil {
+			return 0, fmt.Errorf("could not parse %sSummary.xml: %w", report, err)
+		}
+
+		return summary.Summary.Linecoverage, nil
+	}
+
+	s, err := c.File(report + "Summary.json").Contents(ctx)
+
+	if err != nil {
+		return 0, fmt.Errorf("no coverage summary found in %s", report)
+	}
+
+	var summary coverageSummaryJSON
+
+	if err := json.Unmarshal([]byte(s), &summary); err != nil {
+		return 0, fmt.Errorf("could not parse %sSummary.json: %w", report, err)
+	}
+
+	return summary.Summary.Linecoverage, nil
+}
+
+// checkCoverage fails when line coverage is below the configured minimum
+func (d *Dirk) checkCoverage(ctx context.Context, c *dagger.Container) error {
+	coverage, err := d.lineCoverage(ctx, c)
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Line coverage %.2f%% (minimum %.2f%%)\n", coverage, d.MinCoverage)
+
+	if coverage < d.MinCoverage {
+		return fmt.Errorf("line coverage %.2f%% is below the required %.2f%%", coverage, d.MinCoverage)
+	}
+
+	return nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+func TestCoverageOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		d    Dirk
+		want string
+	}{
+		{
+			name: "defaults",
+			want: "'verbosity:verbose'",
+		},
+		{
+			name: "reports",
+			d:    Dirk{CoverageHtmlReport: true, CoverageBadgeReport: true, Cobertura: true},
+			want: "'generateHtmlReport;generateBadgeReport;generateAdditionalReports;verbosity:verbose'",
+		},
+		{
+			name: "filters",
+			d:    Dirk{CoverageAssemblyFilters: "+Game,-Game.Tests", CoveragePathFilters: "-**/Generated/**"},
+			want: "'assemblyFilters:+Game,-Game.Tests;pathFilters:-**/Generated/**;verbosity:verbose'",
+		},
+		{
+			name: "verbosity",
+			d:    Dirk{CoverageAdditionalMetrics: true, CoverageVerbosity: "minimal"},
+			want: "'generateAdditionalMetrics;verbosity:warning'",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.d.coverageOptions(); got != tt.want {
+				t.Errorf("coverageOptions() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bardic/Dirk/internal/dagger"
+)
+
+// Files describing the contents of a build: the build report, AssetBundle
+// manifests and Addressables catalogs
+var buildManifestPatterns = []string{
+	"**/build-report.json",
+	"**/*.manifest",
+	"**/catalog*.json",
+}
+
+// manifests keeps only the manifest and catalog files of a build, reporting
+// whether there were any
+func (d *Dirk) manifests(ctx context.Context, build *dagger.Directory) (*dagger.Directory, bool, error) {
+	found := false
+
+	for _, pattern := range buildManifestPatterns {
+		paths, err := build.Glob(ctx, pattern)
+
+		if err != nil {
+			return nil, false, fmt.Errorf("could not list %s: %w", pattern, err)
+		}
+
+		if len(paths) > 0 {
+			found = true
+		}
+	}
+
+	return dag.Directory().WithDirectory(".", build, dagger.DirectoryWithDirectoryOpts{
+		Include: buildManifestPatterns,
+	}), found, nil
+}
+
+// Compare the asset manifests and catalogs of two builds
+//
+// The build reports, AssetBundle manifests and Addressables catalogs found in
+// both builds are compared with a unified diff. Files only one build has are
+// listed as such, so a build without manifests still diffs cleanly.
+func (d *Dirk) DiffBuilds(
+	ctx context.Context,
+	// Build to compare against, e.g. the previous release
+	base *dagger.Directory,
+	// Build to compare
+	head *dagger.Directory,
+) (*dagger.File, error) {
+	baseManifests, baseFound, err := d.manifests(ctx, base)
+
+	if err != nil {
+		return nil, fmt.Errorf("base: %w", err)
+	}
+
+	headManifests, headFound, err := d.manifests(ctx, head)
+
+	if err != nil {
+		return nil, fmt.Errorf("head: %w", err)
+	}
+
+	if !baseFound && !headFound {
+		return dag.Directory().WithNewFile("diff.txt", "No manifests or catalogs found in either build\n").File("diff.txt"), nil
+	}
+
+	header := ""
+
+	if !baseFound {
+		header = "The base build has no manifests or catalogs\n"
+	}
+
+	if !headFound {
+		header = "The head build has no manifests or catalogs\n"
+	}
+
+	// diff exits with 1 when the files differ, only 2 is an error
+	c := dag.Container().From("alpine").
+		WithDirectory("/compare/base", baseManifests).
+		WithDirectory("/compare/head", headManifests).
+		WithEnvVariable("DIFF_HEADER", header).
+		WithWorkdir("/compare").
+		WithExec([]string{
+			"sh",
+			"-c",
+			"printf '%s' \"$DIFF_HEADER\" > /diff.txt; diff -ru base head >> /diff.txt; s=$?; [ $s -eq 0 ] && echo 'No differences' >> /diff.txt; test $s -le 1",
+		}, dagger.ContainerWithExecOpts{
+			Expect: dagger.ReturnTypeAny,
+		})
+
+	exitCode, err := c.ExitCode(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if exitCode != 0 {
+		stderr, _ := c.Stderr(ctx)
+		return nil, fmt.Errorf("could not diff the builds: %s", stderr)
+	}
+
+	return c.File("/diff.txt"), nil
+}
@@ -4,6 +4,9 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"path"
+	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/bardic/Dirk/internal/dagger"
@@ -25,12 +28,14 @@ func (e *Env) Host(ctx context.Context, f *dagger.File) error {
 		return err
 	}
 
-	envPair := strings.Split(envs, "\n")
+	pairs, err := parseDotenv(envs)
 
-	for _, v := range envPair {
+	if err != nil {
+		return err
+	}
 
-		envVals := strings.SplitN(v, "=", 2)
-		err := os.Setenv(envVals[0], envVals[1])
+	for _, kv := range pairs {
+		err := os.Setenv(kv[0], kv[1])
 
 		if err != nil {
 			return err
@@ -48,23 +53,84 @@ func (e *Env) Container(ctx context.Context, f *dagger.File, c *dagger.Container
 	envs, err := f.Contents(ctx)
 
 	if err != nil {
-		fmt.Printf("Error reading file: %v\n", err)
+		return nil, fmt.Errorf("could not read the dotenv file: %w", err)
 	}
 
-	envPair := strings.Split(envs, "\n")
+	pairs, err := parseDotenv(envs)
 
-	for _, v := range envPair {
-		envVals := strings.SplitN(v, "=", 2)
+	if err != nil {
+		return nil, err
+	}
 
+	for _, kv := range pairs {
 		if isSecrets {
 			fmt.Println("Secret found")
-			c.WithSecretVariable(envVals[0], dag.SetSecret(envVals[0], envVals[1]))
+			c = c.WithSecretVariable(kv[0], dag.SetSecret(kv[0], kv[1]))
 
 		} else {
 			fmt.Println("Env found")
-			c = c.WithEnvVariable(envVals[0], envVals[1])
+			c = c.WithEnvVariable(kv[0], kv[1])
 		}
 	}
 
 	return c, nil
 }
+
+// parseDotenv returns the KEY=value pairs of a dotenv file, skipping blank
+// lines and # comments. The offending line is not quoted in errors, as it
+// may hold a secret.
+func parseDotenv(contents string) ([][2]string, error) {
+	var pairs [][2]string
+
+	for i, line := range strings.Split(contents, "\n") {
+		line = strings.TrimSpace(line)
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, found := strings.Cut(line, "=")
+
+		if !found || strings.TrimSpace(key) == "" {
+			return nil, fmt.Errorf("invalid dotenv line %d: expected KEY=value", i+1)
+		}
+
+		pairs = append(pairs, [2]string{strings.TrimSpace(key), value})
+	}
+
+	return pairs, nil
+}
+
+// dotenvFile returns the dotenv file name in src, or nil when src has none
+func dotenvFile(ctx context.Context, src *dagger.Directory, name string) (*dagger.File, error) {
+	entries, err := src.Entries(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !slices.Contains(entries, path.Clean(name)) {
+		return nil, nil
+	}
+
+	return src.File(name), nil
+}
+
+// lookupEnvInt parses the integer env var key into value when it is set
+func lookupEnvInt(key string, value *int) error {
+	v, ok := os.LookupEnv(key)
+
+	if !ok {
+		return nil
+	}
+
+	n, err := strconv.Atoi(v)
+
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", key, err)
+	}
+
+	*value = n
+
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+)
+
+func TestEnvContainerSecrets(t *testing.T) {
+	requireEngine(t)
+
+	ctx := context.Background()
+	secrets := dag.Directory().WithNewFile("unity_secrets.env", "# Unity account\nPASS=hunter2\n\n").File("unity_secrets.env")
+
+	c, err := NewEnv().Container(ctx, secrets, dag.Container().From("alpine"), true)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := c.WithExec([]string{"sh", "-c", `printf %s "$PASS"`}).Stdout(ctx)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out != "hunter2" {
+		t.Errorf("PASS = %q, want the secret from the dotenv file", out)
+	}
+}
+
+func TestParseDotenv(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		want     [][2]string
+		err      bool
+	}{
+		{
+			name:     "trailing newline",
+			contents: "DIRK_BUILD_TARGET=Android\n",
+			want:     [][2]string{{"DIRK_BUILD_TARGET", "Android"}},
+		},
+		{
+			name:     "blank lines and comments",
+			contents: "# Build\n\nDIRK_BUILD_TARGET=Android\n  \n# DIRK_DEVELOPMENT=true\n",
+			want:     [][2]string{{"DIRK_BUILD_TARGET", "Android"}},
+		},
+		{
+			name:     "windows line endings",
+			contents: "USER=someone\r\nPASS=a=b\r\n",
+			want:     [][2]string{{"USER", "someone"}, {"PASS", "a=b"}},
+		},
+		{
+			name:     "empty value",
+			contents: "DIRK_LOG_PATH=\n",
+			want:     [][2]string{{"DIRK_LOG_PATH", ""}},
+		},
+		{
+			name:     "empty file",
+			contents: "",
+		},
+		{
+			name:     "missing separator",
+			contents: "DIRK_BUILD_TARGET Android\n",
+			err:      true,
+		},
+		{
+			name:     "missing key",
+			contents: "=Android\n",
+			err:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDotenv(tt.contents)
+
+			if tt.err {
+				if err == nil {
+					t.Errorf("parseDotenv(%q) = %q, want an error", tt.contents, got)
+				}
+
+				return
+			}
+
+			if err != nil || !slices.Equal(got, tt.want) {
+				t.Errorf("parseDotenv(%q) = %q, %v, want %q", tt.contents, got, err, tt.want)
+			}
+		})
+	}
+}
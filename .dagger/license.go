@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bardic/Dirk/internal/dagger"
+)
+
+// Licensing client used to lease floating licenses from a license server
+const licensingClient = "/opt/unity/Editor/Data/Resources/Licensing/Client/Unity.Licensing.Client"
+
+// Log written by the licensing client of the root user in GameCI images
+const licensingLogPath = "/root/.config/unity3d/Unity/Unity.Licensing.Client.log"
+
+// Activation attempts made when none are configured
+const defaultActivationRetries = 3
+
+// Delay before the first activation retry, doubled on every attempt
+const activationBackoff = 10 * time.Second
+
+// Output fragments of activation failures that are worth retrying
+var transientLicenseMarkers = []string{
+	"timed out",
+	"timeout",
+	"connection refused",
+	"connection reset",
+	"could not resolve host",
+	"temporary failure",
+	"service unavailable",
+	"bad gateway",
+	"gateway timeout",
+}
+
+// Output fragments of activation failures that retrying won't fix
+var authLicenseMarkers = []string{
+	"invalid username or password",
+	"invalid serial",
+	"invalid credentials",
+	"unauthorized",
+}
+
+// Output fragments showing the editor has no license, after which it opens a
+// dialog that hangs forever under xvfb
+var missingLicenseMarkers = []string{
+	"No valid Unity Editor license found",
+	"Unable to find Unity license",
+}
+
+// checkActivationOutput fails when the activation output shows the editor
+// ended up without a license, instead of letting the next step hang
+func (d *Dirk) checkActivationOutput(ctx context.Context, c *dagger.Container) error {
+	stdout, _ := c.Stdout(ctx)
+	stderr, _ := c.Stderr(ctx)
+
+	for _, line := range strings.Split(stdout+"\n"+stderr, "\n") {
+		for _, marker := range missingLicenseMarkers {
+			if strings.Contains(line, marker) {
+				return fmt.Errorf("license activation failed, the editor has no valid license: %s", strings.TrimSpace(line))
+			}
+		}
+	}
+
+	return nil
+}
+
+// activate runs the activation cmd, retrying with exponential backoff as long
+// as the failure looks transient. When every attempt fails the last one is
+// returned with the error so its logs can be read.
+func (d *Dirk) activate(ctx context.Context, c *dagger.Container, cmd []string) (*dagger.Container, error) {
+	attempts := d.ActivationRetries
+
+	if attempts <= 0 {
+		attempts = defaultActivationRetries
+	}
+
+	backoff := activationBackoff
+
+	for attempt := 1; ; attempt++ {
+		fmt.Printf("License activation attempt %d/%d\n", attempt, attempts)
+
+		ac := c
+
+		if attempt > 1 {
+			// Dagger would otherwise hand back the cached failed attempt
+			ac = ac.WithEnvVariable("DIRK_ACTIVATION_ATTEMPT", strconv.Itoa(attempt)+" "+time.Now().String())
+		}
+
+		ac, err := d.runStep(ctx, ac.WithExec(cmd, dagger.ContainerWithExecOpts{
+			Expect: dagger.ReturnTypeAny,
+		}), "license activation")
+
+		if err != nil {
+			return nil, err
+		}
+
+		exitCode, err := ac.ExitCode(ctx)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if exitCode == 0 {
+			return ac, nil
+		}
+
+		stdout, _ := ac.Stdout(ctx)
+		stderr, _ := ac.Stderr(ctx)
+
+		if !isTransientLicenseFailure(stdout+stderr) || attempt >= attempts {
+			return ac, fmt.Errorf("license activation failed with exit code %d after %d attempt(s)", exitCode, attempt)
+		}
+
+		fmt.Printf("License activation failed with a transient error, retrying in %s\n", backoff)
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+	}
+}
+
+func isTransientLicenseFailure(output string) bool {
+	output = strings.ToLower(output)
+
+	for _, marker := range authLicenseMarkers {
+		if strings.Contains(output, marker) {
+			return false
+		}
+	}
+
+	for _, marker := range transientLicenseMarkers {
+		if strings.Contains(output, marker) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Fields of services-config.json the licensing client needs to lease a
+// floating license
+type serviceConfig struct {
+	LicensingServiceBaseUrl    *string `json:"licensingServiceBaseUrl"`
+	EnableEntitlementLicensing *bool   `json:"enableEntitlementLicensing"`
+}
+
+// checkServiceConfig validates the service config before it is mounted, as the
+// licensing client only reports a malformed one once activation times out
+func (d *Dirk) checkServiceConfig(ctx context.Context) error {
+	contents, err := d.ServiceConfig.Contents(ctx)
+
+	if err != nil {
+		return fmt.Errorf("could not read service config: %w", err)
+	}
+
+	var config serviceConfig
+
+	if err := json.Unmarshal([]byte(contents), &config); err != nil {
+		return fmt.Errorf("service config is not valid JSON: %w", err)
+	}
+
+	if config.LicensingServiceBaseUrl == nil || *config.LicensingServiceBaseUrl == "" {
+		return fmt.Errorf("service config is missing licensingServiceBaseUrl")
+	}
+
+	if u, err := url.Parse(*config.LicensingServiceBaseUrl); err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("service config licensingServiceBaseUrl %q is not a valid URL", *config.LicensingServiceBaseUrl)
+	}
+
+	if config.EnableEntitlementLicensing == nil {
+		return fmt.Errorf("service config is missing enableEntitlementLicensing")
+	}
+
+	return nil
+}
+
+// withLicensingLog prints the licensing client log of a failed activation and
+// keeps it as the run's log, so it is returned by build-log and test-log
+func (d *Dirk) withLicensingLog(ctx context.Context, c *dagger.Container) {
+	log := c.File(licensingLogPath)
+
+	contents, err := log.Contents(ctx)
+
+	if err != nil {
+		fmt.Println("No licensing client log found at " + licensingLogPath)
+		return
+	}
+
+	fmt.Println("Licensing client log:\n" + contents)
+
+	d.Log = log
+}
+
+// Quoted values in the --acquire-floating output. The second one is the
+// lease token, as relied upon by GameCI's own license server support.
+var floatingLicenseValuePattern = regexp.MustCompile(`"([^"]*)"`)
+
+func parseFloatingLicense(output string) string {
+	values := floatingLicenseValuePattern.FindAllStringSubmatch(output, -1)
+
+	if len(values) < 2 {
+		return ""
+	}
+
+	return values[1][1]
+}
+
+// returnFloatingLicense hands the leased seat back to the license server and
+// logs the outcome
+func (d *Dirk) returnFloatingLicense(ctx context.Context, c *dagger.Container) {
+	if d.FloatingLicense == "" {
+		fmt.Println("Failed to return floating license: no lease token found")
+		return
+	}
+
+	exitCode, err := c.WithExec([]string{
+		licensingClient,
+		"--return-floating",
+		d.FloatingLicense,
+	}, dagger.ContainerWithExecOpts{
+		Expect: dagger.ReturnTypeAny,
+	}).ExitCode(ctx)
+
+	if err != nil {
+		fmt.Printf("Failed to return floating license: %v\n", err)
+		return
+	}
+
+	if exitCode != 0 {
+		fmt.Printf("Failed to return floating license, exit code %d\n", exitCode)
+		return
+	}
+
+	fmt.Println("Returned floating license " + d.FloatingLicense)
+}
+
+// License file written by a serial or personal activation in GameCI images
+const licenseFilePath = "/root/.local/share/unity3d/Unity/Unity_lic.ulf"
+
+// Active license, as reported by license-status
+type LicenseStatus struct {
+	Type   string // License the editor runs with, e.g. UnityPro or UnityPersonal
+	Serial string // Masked serial, or the lease token of a floating license
+	Expiry string // When the license stops being valid, empty when it doesn't
+}
+
+// licenseStatus asks the editor which license it runs with, rather than
+// trusting the ULF handed to the activation. The licensing client's own
+// --showEntitlements isn't used: it lists the entitlements the client holds,
+// while a fallback, e.g. from a ULF issued for another machine or Unity
+// version, only shows once the editor resolves its license at startup. The
+// log lines parsed are the ones GameCI relies on, see parseLicenseStatus.
+func (d *Dirk) licenseStatus(ctx context.Context, c *dagger.Container) (*LicenseStatus, error) {
+	c, err := d.runStep(ctx, c.WithExec(append(d.baseCommand(), "-quit", "-logFile", "/dev/stdout"),
+		dagger.ContainerWithExecOpts{
+			Expect: dagger.ReturnTypeAny,
+		},
+	), "license status")
+
+	if err != nil {
+		return nil, err
+	}
+
+	log, err := c.Stdout(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := parseLicenseStatus(log)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Floating leases have no serial, their token identifies them instead
+	if d.ServiceConfig != nil && status.Serial == "" {
+		status.Serial = d.FloatingLicense
+	}
+
+	fmt.Printf("License type: %s, serial: %s, expiry: %s\n", status.Type, status.Serial, status.Expiry)
+
+	return status, nil
+}
+
+// Serial line of the editor log, e.g.
+// [Licensing::Module] Serial number assigned to: "F4-XXXX-XXXX-XXXX-XXXX-XXXX"
+var licenseSerialPattern = regexp.MustCompile(`Serial number assigned to: "([^"]*)"`)
+
+// parseLicenseStatus reads the license group the editor logs once it resolved
+// its entitlements, e.g.
+//
+//	[Licensing::Module] License group:
+//	  Id: UnityPro
+//	  Product: Unity Pro
+//	  Type: Assigned
+//	  Expiration: Unlimited
+func parseLicenseStatus(log string) (*LicenseStatus, error) {
+	lines := strings.Split(log, "\n")
+	status := &LicenseStatus{}
+	found := false
+
+	for i, line := range lines {
+		if m := licenseSerialPattern.FindStringSubmatch(line); m != nil {
+			status.Serial = m[1]
+		}
+
+		if found || !strings.Contains(line, "License group:") {
+			continue
+		}
+
+		found = true
+
+		// The group's fields follow, indented, until the next log line
+		for _, field := range lines[i+1:] {
+			key, value, ok := strings.Cut(strings.TrimSpace(field), ":")
+
+			if !ok || !strings.HasPrefix(field, " ") {
+				break
+			}
+
+			switch value = strings.TrimSpace(value); key {
+			case "Id":
+				status.Type = value
+			case "Expiration":
+				if value != "Unlimited" {
+					status.Expiry = value
+				}
+			}
+		}
+	}
+
+	if !found || status.Type == "" {
+		return nil, fmt.Errorf("the editor did not report an active license")
+	}
+
+	return status, nil
+}
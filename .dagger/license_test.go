@@ -0,0 +1,106 @@
+package main
+
+import "testing"
+
+func TestParseLicenseStatus(t *testing.T) {
+	log := `[Licensing::Client] Successfully resolved entitlement details
+[Licensing::Module] License group:
+  Id: UnityPersonal
+  Product: Unity Personal
+  Type: ULF
+  Expiration: 2027-01-01T00:00:00Z
+[Licensing::Module] Serial number assigned to: "F4-XXXX-XXXX-XXXX-XXXX-XXXX"
+`
+
+	status, err := parseLicenseStatus(log)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := LicenseStatus{Type: "UnityPersonal", Serial: "F4-XXXX-XXXX-XXXX-XXXX-XXXX", Expiry: "2027-01-01T00:00:00Z"}
+
+	if *status != want {
+		t.Errorf("parseLicenseStatus() = %+v, want %+v", *status, want)
+	}
+}
+
+func TestParseLicenseStatusUnlimited(t *testing.T) {
+	log := `[Licensing::Module] License group:
+  Id: UnityPro
+  Expiration: Unlimited
+Loading GUID <-> Path mappings...
+  Id: NotALicense
+`
+
+	status, err := parseLicenseStatus(log)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status.Type != "UnityPro" || status.Expiry != "" || status.Serial != "" {
+		t.Errorf("parseLicenseStatus() = %+v, want an unlimited UnityPro license", *status)
+	}
+}
+
+func TestParseLicenseStatusNoLicense(t *testing.T) {
+	if _, err := parseLicenseStatus("No valid Unity Editor license found. Please activate your license.\n"); err == nil {
+		t.Error("expected an error without a license group")
+	}
+}
+
+func TestIsTransientLicenseFailure(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   bool
+	}{
+		{name: "timeout", output: "[Licensing::Client] Error: Request timed out", want: true},
+		{name: "marker in any case", output: "Connection Refused by license.unity3d.com", want: true},
+		{name: "bad gateway", output: "HTTP 502 Bad Gateway", want: true},
+		{name: "invalid credentials", output: "Invalid username or password", want: false},
+		{name: "auth failure wins", output: "Unauthorized after the connection reset", want: false},
+		{name: "other failure", output: "No valid Unity Editor license found", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTransientLicenseFailure(tt.output); got != tt.want {
+				t.Errorf("isTransientLicenseFailure(%q) = %t, want %t", tt.output, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFloatingLicense(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   string
+	}{
+		{
+			name:   "lease",
+			output: `Successfully acquired floating license "Unity Pro" with token "0f1b3c5d-7e9f-4a2b-8c6d-1e3f5a7b9c0d" until "2026-10-16T12:00:00Z"`,
+			want:   "0f1b3c5d-7e9f-4a2b-8c6d-1e3f5a7b9c0d",
+		},
+		{
+			name:   "single value",
+			output: `Failed to acquire floating license "Unity Pro"`,
+			want:   "",
+		},
+		{
+			name:   "no output",
+			output: "",
+			want:   "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseFloatingLicense(tt.output); got != tt.want {
+				t.Errorf("parseFloatingLicense(%q) = %q, want %q", tt.output, got, tt.want)
+			}
+		})
+	}
+}
@@ -4,549 +4,5210 @@ package main
 
 import (
 	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/bardic/Dirk/internal/dagger"
 )
 
+// NUnit 3 to JUnit transform used when JUnit results are requested without
+// a transform
+//
+//go:embed nunit3-junit.xslt
+var defaultJunitTransform string
+
 // Dirk
 type Dirk struct {
-	BuildName          string            // Unity Build Name
-	BuildTarget        string            // Unity Build Target
-	GameciVersion      string            // GameCI Version
-	JunitTransform     *dagger.File      // Junit Transform Path
-	Os                 string            // GameCI base OS
-	Pass               *dagger.Secret    // Unity Account Password
-	Platform           string            // Unity Build Target Platform
-	Serial             *dagger.Secret    // Unity Serial
-	ServiceConfig      *dagger.File      // Unity Service Config for Licesning Server
-	Src                *dagger.Directory // Source directory of the Unity project
-	TestingingPlatform string            //If should test as editor or playback
-	Ulf                *dagger.File      // Unity Personal License File
-	UnityVersion       string            // Unity Version that GameCI should use
-	User               string            // Unity Account Username
-
+	Accelerator               string            // Unity Accelerator endpoint as host:port
+	AcceleratorNamespace      string            // Namespace prefix on the Unity Accelerator
+	ActivationRetries         int               // License activation attempts on transient failures
+	AndroidAppBundle          bool              // Build an Android App Bundle instead of an APK
+	Architecture              string            // Standalone architecture: x64, arm64 or universal
+	BootScene                 string            // Scene built first, ahead of the other scenes
+	BuildAddressables         bool              // Build Addressables content before the player
+	BuildCache                bool              // Reuse identical builds from the content-addressed build cache
+	BuildExitCode             int               // Exit code of the last Unity build
+	BuildMethod               string            // Static method Unity executes to build
+	BuildMetrics              bool              // Write build-metrics.json with the size of the build
+	BuildName                 string            // Unity Build Name
+	BuildNumber               int               // Android bundleVersionCode / iOS buildNumber
+	BuildTarget               string            // Unity Build Target
+	BundleVersion             string            // PlayerSettings.bundleVersion
+	CacheKey                  string            // Library cache volume name
+	Cobertura                 bool              // Also return coverage as Cobertura XML
+	Coverage                  bool              // Collect code coverage while testing
+	CoverageAdditionalMetrics bool              // Add additional metrics to the coverage results
+	CoverageAssemblyFilters   string            // Code coverage assembly filters, e.g. +MyGame.*,-UnityEngine.*
+	CoverageBadgeReport       bool              // Generate coverage badges
+	CoverageHistory           *dagger.Directory // Coverage history of previous runs
+	CoverageHistoryPath       string            // Coverage history directory under /results
+	CoverageHtmlReport        bool              // Generate the HTML coverage report
+	CoverageHtmlReportHistory bool              // Generate the HTML coverage report history
+	CoveragePathFilters       string            // Code coverage path filters
+	CoverageResultsPath       string            // Coverage results directory under /results
+	CoverageVerbosity         string            // Code coverage log verbosity: minimal, normal or verbose
+	Defines                   []string          // Scripting define symbols
+	Deterministic             bool              // Run the editor with a fixed locale and timezone and deterministic compilation
+	Development               bool              // Development build with script debugging
+	DryRun                    bool              // Resolve the image and mount the source without running the editor
+	ExportLibrary             bool              // Return the Library snapshot with the build
+	ExtraArgs                 []string          // Raw editor arguments appended after the known flags
+	Fast                      bool              // Skip the assembly updater and rely on the Library cache for quick local iteration
+	FloatingLicense           string            // Token of the floating license acquired from the license server
+	ForceRebuild              bool              // Build even when the build cache has a matching build
+	GameciVersion             string            // GameCI Version
+	Graphics                  bool              // Run the editor with graphics instead of -nographics
+	GraphicsApi               string            // Graphics API forced on the editor: glcore, vulkan or d3d11
+	HttpProxy                 string            // HTTP_PROXY for the editor and licensing client
+	HttpsProxy                string            // HTTPS_PROXY for the editor and licensing client
+	Il2cppArgs                []string          // Additional IL2CPP arguments, such as --compiler-flags
+	IncludeProject            bool              // Return the project source with the test results
+	Junit                     bool              // Convert the test results to JUnit
+	JunitTransform            *dagger.File      // Junit Transform Path
+	KeepLicense               bool              // Skip returning the license once the editor is done
+	Keystore                  *dagger.File      // Android keystore
+	KeystoreAlias             string            // Android keystore alias name
+	KeystoreAliasPass         *dagger.Secret    // Android keystore alias password
+	KeystorePass              *dagger.Secret    // Android keystore password
+	LibrarySeed               *dagger.Directory // Library snapshot used to prime an empty Library cache
+	LicensingVerbose          bool              // Log verbosely from the licensing client
+	Log                       *dagger.File      // Unity log of the last editor run
+	LogPath                   string            // Editor log destination replacing the default unity.log
+	MaxBuildSize              int               // Build size budget in MB, 0 for none
+	MinCoverage               float64           // Minimum line coverage percentage for tests to pass
+	NoCache                   bool              // Bust Dagger's cache for every step
+	NoLibraryCache            bool              // Import into a fresh Library instead of mounting the Library cache
+	NoProxy                   string            // NO_PROXY for the editor and licensing client
+	Os                        string            // GameCI base OS
+	OutputLayout              string            // Build output layout: flat or nested
+	OutputName                string            // Top folder of the returned directory, for exporting several runs side by side
+	PackageCacheKey           string            // Name of the UPM package cache volume
+	PackagesManifest          *dagger.File      // Replacement for Packages/manifest.json
+	Pass                      *dagger.Secret    // Unity Account Password
+	Platform                  string            // Unity Build Target Platform
+	PlatformArch              string            // Container platform of the editor image: linux/amd64 or linux/arm64
+	PlayerSettings            *dagger.File      // JSON player settings overrides applied by the build method
+	PostBuildScript           *dagger.File      // Shell script run in the build directory after a successful build
+	PreBuildScript            *dagger.File      // Shell script run in /src before the editor starts
+	Registry                  string            // Registry mirroring unityci/editor
+	RegistryPass              *dagger.Secret    // Registry password or token
+	RegistryUser              string            // Registry username
+	ResolvConf                *dagger.File      // resolv.conf replacing the DNS configuration of the editor container
+	ResultsName               string            // File name of the test results under /results
+	RetryFailed               int               // Times failed tests are re-run before the run fails
+	SaxonImage                string            // Image providing saxonb-xslt for the JUnit transform
+	Scenes                    []string          // Scenes to build instead of the enabled build settings scenes
+	ScreenDepth               int               // xvfb screen depth
+	ScreenHeight              int               // xvfb screen height
+	Screens                   []string          // xvfb screens as WIDTHxHEIGHTxDEPTH, replacing the single default screen
+	ScreenWidth               int               // xvfb screen width
+	ScriptingBackend          string            // Scripting backend: il2cpp or mono2x
+	Serial                    *dagger.Secret    // Unity Serial
+	ServerBuild               bool              // Build the Dedicated Server subtarget of standalone targets
+	ServiceConfig             *dagger.File      // Unity Service Config for Licesning Server
+	SigningCert               *dagger.File      // PKCS#12 certificate used to codesign macOS builds
+	SigningCertPass           *dagger.Secret    // Password of the signing certificate
+	SigningIdentity           string            // Codesign identity for macOS builds
+	Src                       *dagger.Directory // Source directory of the Unity project
+	Summary                   *TestSummary      // Counts of the last test run
+	TestAssembly              string            // Test assemblies to run, separated by ;
+	TestAssemblyNames         []string          // Test assembly definitions to run
+	TestCategory              string            // NUnit test categories to run, separated by ;
+	TestingingPlatform        string            //If should test as editor or playback
+	TextureCompression        string            // Android texture compression: generic, dxt, pvrtc, etc, etc2 or astc
+	Timeout                   int               // Minutes before an editor step is cancelled
+	Ulf                       *dagger.File      // Unity Personal License File
+	UlfDir                    *dagger.Directory // Unity Personal License Files named after the Unity version they activate
+	UnityVersion              string            // Unity Version that GameCI should use
+	User                      string            // Unity Account Username
+	Verbose                   bool              // Stream the Unity log to stdout while the editor runs
+	WarningsAsErrors          bool              // Fail the build on script compilation warnings
+	WebglCompression          string            // WebGL compression format: gzip, brotli or disabled
 }
 
-// Build the things
-func (d *Dirk) Build(
-	ctx context.Context,
-	gameSrc *dagger.Directory,
+// New takes the editor, image and license settings shared by every function.
+// Like the arguments of the functions, they override the DIRK_ env vars and
+// dotenv files, e.g. dagger call --ulf=./Unity_lic.ulf build --game-src=.
+func New(
 	// +optional
-	buildName string,
+	accelerator string,
 	// +optional
-	buildTarget string,
+	acceleratorNamespace string,
+	// +optional
+	activationRetries int,
+	// +optional
+	cacheKey string,
+	// +optional
+	defines []string,
+	// +optional
+	deterministic bool,
+	// +optional
+	extraArgs []string,
+	// +optional
+	fast bool,
 	// +optional
 	gameciVersion string,
 	// +optional
+	graphics bool,
+	// +optional
+	graphicsApi string,
+	// +optional
+	httpProxy string,
+	// +optional
+	httpsProxy string,
+	// +optional
+	keepLicense bool,
+	// +optional
+	librarySeed *dagger.Directory,
+	// +optional
+	licensingVerbose bool,
+	// +optional
+	logPath string,
+	// +optional
+	noCache bool,
+	// +optional
+	noLibraryCache bool,
+	// +optional
+	noProxy string,
+	// +optional
+	outputName string,
+	// +optional
+	packageCacheKey string,
+	// +optional
+	packagesManifest *dagger.File,
+	// +optional
 	pass *dagger.Secret,
 	// +optional
 	platform string,
 	// +optional
+	platformArch string,
+	// +optional
+	preBuildScript *dagger.File,
+	// +optional
+	registry string,
+	// +optional
+	registryPass *dagger.Secret,
+	// +optional
+	registryUser string,
+	// +optional
+	resolvConf *dagger.File,
+	// +optional
+	screenDepth int,
+	// +optional
+	screenHeight int,
+	// +optional
+	screens []string,
+	// +optional
+	screenWidth int,
+	// +optional
 	serial *dagger.Secret,
 	// +optional
 	serviceConfig *dagger.File,
 	// +optional
 	targetOs string,
 	// +optional
+	timeout int,
+	// +optional
 	ulf *dagger.File,
 	// +optional
+	ulfDir *dagger.Directory,
+	// +optional
 	unityVersion string,
 	// +optional
 	user string,
+	// +optional
+	verbose bool,
+) *Dirk {
+	return &Dirk{
+		Accelerator:          accelerator,
+		AcceleratorNamespace: acceleratorNamespace,
+		ActivationRetries:    activationRetries,
+		CacheKey:             cacheKey,
+		Defines:              defines,
+		Deterministic:        deterministic,
+		ExtraArgs:            extraArgs,
+		Fast:                 fast,
+		GameciVersion:        gameciVersion,
+		Graphics:             graphics,
+		GraphicsApi:          graphicsApi,
+		HttpProxy:            httpProxy,
+		HttpsProxy:           httpsProxy,
+		KeepLicense:          keepLicense,
+		LibrarySeed:          librarySeed,
+		LicensingVerbose:     licensingVerbose,
+		LogPath:              logPath,
+		NoCache:              noCache,
+		NoLibraryCache:       noLibraryCache,
+		NoProxy:              noProxy,
+		OutputName:           outputName,
+		PackageCacheKey:      packageCacheKey,
+		PackagesManifest:     packagesManifest,
+		Pass:                 pass,
+		Platform:             platform,
+		PlatformArch:         platformArch,
+		PreBuildScript:       preBuildScript,
+		Registry:             registry,
+		RegistryPass:         registryPass,
+		RegistryUser:         registryUser,
+		ResolvConf:           resolvConf,
+		ScreenDepth:          screenDepth,
+		ScreenHeight:         screenHeight,
+		Screens:              screens,
+		ScreenWidth:          screenWidth,
+		Serial:               serial,
+		ServiceConfig:        serviceConfig,
+		Os:                   targetOs,
+		Timeout:              timeout,
+		Ulf:                  ulf,
+		UlfDir:               ulfDir,
+		UnityVersion:         unityVersion,
+		User:                 user,
+		Verbose:              verbose,
+	}
+}
+
+// Build the things
+func (d *Dirk) Build(
+	ctx context.Context,
+	gameSrc *dagger.Directory,
+	// +optional
+	androidAppBundle bool,
+	// +optional
+	architecture string,
+	// +optional
+	bootScene string,
+	// +optional
+	buildAddressables bool,
+	// +optional
+	buildCache bool,
+	// +optional
+	buildMethod string,
+	// +optional
+	buildMetrics bool,
+	// +optional
+	buildName string,
+	// +optional
+	buildNumber int,
+	// +optional
+	buildTarget string,
+	// +optional
+	bundleVersion string,
+	// +optional
+	development bool,
+	// +optional
+	dryRun bool,
+	// +optional
+	exportLibrary bool,
+	// +optional
+	forceRebuild bool,
+	// +optional
+	il2cppArgs []string,
+	// +optional
+	keystore *dagger.File,
+	// +optional
+	keystoreAlias string,
+	// +optional
+	keystoreAliasPass *dagger.Secret,
+	// +optional
+	keystorePass *dagger.Secret,
+	// +optional
+	maxBuildSize int,
+	// +optional
+	outputLayout string,
+	// +optional
+	playerSettings *dagger.File,
+	// +optional
+	postBuildScript *dagger.File,
+	// +optional
+	scenes []string,
+	// +optional
+	scriptingBackend string,
+	// +optional
+	serverBuild bool,
+	// +optional
+	signingCert *dagger.File,
+	// +optional
+	signingCertPass *dagger.Secret,
+	// +optional
+	signingIdentity string,
+	// +optional
+	textureCompression string,
+	// +optional
+	warningsAsErrors bool,
+	// +optional
+	webglCompression string,
 ) (*dagger.Directory, error) {
-	gameSrc = gameSrc.WithoutDirectory(".git")
-	gameSrc = gameSrc.WithoutDirectory(".dagger")
-	gameSrc = gameSrc.WithoutDirectory(".vscode")
-	gameSrc = gameSrc.WithoutFiles([]string{".gitignore", ".gitmodules", ".DS_Store", "dagger.json", "go.work", "LICENSE", "README.md"})
+	err := d.configureBuild(gameSrc, buildOptions{
+		AndroidAppBundle:   androidAppBundle,
+		Architecture:       architecture,
+		BootScene:          bootScene,
+		BuildAddressables:  buildAddressables,
+		BuildCache:         buildCache,
+		BuildMethod:        buildMethod,
+		BuildMetrics:       buildMetrics,
+		BuildName:          buildName,
+		BuildNumber:        buildNumber,
+		BuildTarget:        buildTarget,
+		BundleVersion:      bundleVersion,
+		Development:        development,
+		DryRun:             dryRun,
+		ExportLibrary:      exportLibrary,
+		ForceRebuild:       forceRebuild,
+		Il2cppArgs:         il2cppArgs,
+		Keystore:           keystore,
+		KeystoreAlias:      keystoreAlias,
+		KeystoreAliasPass:  keystoreAliasPass,
+		KeystorePass:       keystorePass,
+		MaxBuildSize:       maxBuildSize,
+		OutputLayout:       outputLayout,
+		PlayerSettings:     playerSettings,
+		PostBuildScript:    postBuildScript,
+		Scenes:             scenes,
+		ScriptingBackend:   scriptingBackend,
+		ServerBuild:        serverBuild,
+		SigningCert:        signingCert,
+		SigningCertPass:    signingCertPass,
+		SigningIdentity:    signingIdentity,
+		TextureCompression: textureCompression,
+		WarningsAsErrors:   warningsAsErrors,
+		WebglCompression:   webglCompression,
+	})
 
-	d.Src = gameSrc
+	if err != nil {
+		return nil, err
+	}
 
-	var err error
-	d.UnityVersion, err = d.determineUnityProjectVersion()
+	builds, err := d.buildProject(ctx)
 
 	if err != nil {
 		return nil, err
 	}
 
-	var f, s *dagger.File
+	return d.withOutputName(builds), nil
+}
 
-	f = gameSrc.File("./unity.env")
+// buildProject builds the configured project, reusing a cached build when
+// the build cache has one
+func (d *Dirk) buildProject(ctx context.Context) (*dagger.Directory, error) {
+	if d.DryRun {
+		return d.dryRun(ctx)
+	}
 
-	if f != nil {
-		NewEnv().Host(context.Background(), f)
+	if err := d.checkMacSigning(ctx, []string{d.BuildTarget}); err != nil {
+		return nil, err
 	}
 
-	d.BuildName = os.Getenv("DIRK_BUILD_NAME")
-	d.BuildTarget = os.Getenv("DIRK_BUILD_TARGET")
-	d.GameciVersion = os.Getenv("DIRK_GAMECI_VERSION")
+	var buildKey string
 
-	d.Os = os.Getenv("DIRK_OS")
+	if d.BuildCache {
+		key, err := d.buildCacheKey(ctx)
 
-	if _, b := os.LookupEnv("DIRK_PASS"); b {
-		d.Pass = dag.Secret(os.Getenv("DIRK_PASS"))
+		if err != nil {
+			return nil, err
+		}
+
+		buildKey = key
+
+		if cached, ok := d.cachedBuild(ctx, buildKey); ok {
+			return cached, nil
+		}
 	}
-	d.Platform = os.Getenv("DIRK_PLATFORM")
 
-	if _, b := os.LookupEnv("DIRK_SERIAL"); b {
-		d.Serial = dag.Secret(os.Getenv("DIRK_SERIAL"))
+	c, err := d.createBuildContainer(ctx)
+
+	if err != nil {
+		return nil, err
 	}
 
-	if _, b := os.LookupEnv("DIRK_SERVICE_CONFIG"); b {
-		d.ServiceConfig = gameSrc.File(os.Getenv("DIRK_SERVICE_CONFIG"))
+	defer func() {
+		d.releaseLicense(ctx, c)
+	}()
+
+	buildPath := d.buildPath("/builds/")
+
+	built, err := d.build(ctx, c, buildPath)
+
+	if err != nil {
+		return nil, err
 	}
 
-	if _, b := os.LookupEnv("DIRK_ULF"); b {
-		d.Ulf = gameSrc.File(os.Getenv("DIRK_ULF"))
+	c = built
+
+	finished, err := d.finishBuild(ctx, c, buildPath)
+
+	if err != nil {
+		return nil, err
 	}
 
-	if _, b := os.LookupEnv("DIRK_UNITY_VERSION"); b {
-		d.UnityVersion = os.Getenv("DIRK_UNITY_VERSION")
+	c = finished
+
+	artifact := d.getBuildArtifact(c)
+
+	if d.ExportLibrary {
+		artifact = artifact.WithDirectory("Library", d.getLibrary(c))
 	}
 
-	d.User = os.Getenv("DIRK_USER")
+	if d.BuildCache {
+		if err := d.storeBuild(ctx, buildKey, artifact); err != nil {
+			return nil, err
+		}
+	}
 
-	if buildName != "" {
-		d.BuildName = buildName
+	return artifact, nil
+}
+
+// Build the things and archive them into a single zip
+//
+// The archive is named after the build name and target and preserves file
+// permissions, so standalone Linux and macOS executables stay runnable.
+func (d *Dirk) BuildZip(
+	ctx context.Context,
+	gameSrc *dagger.Directory,
+	// +optional
+	androidAppBundle bool,
+	// +optional
+	architecture string,
+	// +optional
+	bootScene string,
+	// +optional
+	buildAddressables bool,
+	// +optional
+	buildCache bool,
+	// +optional
+	buildMethod string,
+	// +optional
+	buildMetrics bool,
+	// +optional
+	buildName string,
+	// +optional
+	buildNumber int,
+	// +optional
+	buildTarget string,
+	// +optional
+	bundleVersion string,
+	// +optional
+	development bool,
+	// +optional
+	exportLibrary bool,
+	// +optional
+	forceRebuild bool,
+	// +optional
+	il2cppArgs []string,
+	// +optional
+	keystore *dagger.File,
+	// +optional
+	keystoreAlias string,
+	// +optional
+	keystoreAliasPass *dagger.Secret,
+	// +optional
+	keystorePass *dagger.Secret,
+	// +optional
+	maxBuildSize int,
+	// +optional
+	outputLayout string,
+	// +optional
+	playerSettings *dagger.File,
+	// +optional
+	postBuildScript *dagger.File,
+	// +optional
+	scenes []string,
+	// +optional
+	scriptingBackend string,
+	// +optional
+	serverBuild bool,
+	// +optional
+	signingCert *dagger.File,
+	// +optional
+	signingCertPass *dagger.Secret,
+	// +optional
+	signingIdentity string,
+	// +optional
+	textureCompression string,
+	// +optional
+	warningsAsErrors bool,
+	// +optional
+	webglCompression string,
+) (*dagger.File, error) {
+	err := d.configureBuild(gameSrc, buildOptions{
+		AndroidAppBundle:   androidAppBundle,
+		Architecture:       architecture,
+		BootScene:          bootScene,
+		BuildAddressables:  buildAddressables,
+		BuildCache:         buildCache,
+		BuildMethod:        buildMethod,
+		BuildMetrics:       buildMetrics,
+		BuildName:          buildName,
+		BuildNumber:        buildNumber,
+		BuildTarget:        buildTarget,
+		BundleVersion:      bundleVersion,
+		Development:        development,
+		ExportLibrary:      exportLibrary,
+		ForceRebuild:       forceRebuild,
+		Il2cppArgs:         il2cppArgs,
+		Keystore:           keystore,
+		KeystoreAlias:      keystoreAlias,
+		KeystoreAliasPass:  keystoreAliasPass,
+		KeystorePass:       keystorePass,
+		MaxBuildSize:       maxBuildSize,
+		OutputLayout:       outputLayout,
+		PlayerSettings:     playerSettings,
+		PostBuildScript:    postBuildScript,
+		Scenes:             scenes,
+		ScriptingBackend:   scriptingBackend,
+		ServerBuild:        serverBuild,
+		SigningCert:        signingCert,
+		SigningCertPass:    signingCertPass,
+		SigningIdentity:    signingIdentity,
+		TextureCompression: textureCompression,
+		WarningsAsErrors:   warningsAsErrors,
+		WebglCompression:   webglCompression,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	builds, err := d.buildProject(ctx)
+
+	if err != nil {
+		return nil, err
 	}
 
-	if buildTarget != "" {
-		d.BuildTarget = buildTarget
+	return d.archive(builds, d.BuildName+"-"+d.BuildTarget+".zip"), nil
+}
+
+// Return the editor command build would run, without running it
+//
+// Credentials are redacted. Scripting defines are written to Assets/csc.rsp
+// rather than passed on the command line, so they are listed after it.
+func (d *Dirk) BuildCommand(
+	gameSrc *dagger.Directory,
+	// +optional
+	androidAppBundle bool,
+	// +optional
+	architecture string,
+	// +optional
+	bootScene string,
+	// +optional
+	buildAddressables bool,
+	// +optional
+	buildCache bool,
+	// +optional
+	buildMethod string,
+	// +optional
+	buildMetrics bool,
+	// +optional
+	buildName string,
+	// +optional
+	buildNumber int,
+	// +optional
+	buildTarget string,
+	// +optional
+	bundleVersion string,
+	// +optional
+	development bool,
+	// +optional
+	exportLibrary bool,
+	// +optional
+	forceRebuild bool,
+	// +optional
+	il2cppArgs []string,
+	// +optional
+	keystore *dagger.File,
+	// +optional
+	keystoreAlias string,
+	// +optional
+	keystoreAliasPass *dagger.Secret,
+	// +optional
+	keystorePass *dagger.Secret,
+	// +optional
+	maxBuildSize int,
+	// +optional
+	outputLayout string,
+	// +optional
+	playerSettings *dagger.File,
+	// +optional
+	postBuildScript *dagger.File,
+	// +optional
+	scenes []string,
+	// +optional
+	scriptingBackend string,
+	// +optional
+	serverBuild bool,
+	// +optional
+	signingCert *dagger.File,
+	// +optional
+	signingCertPass *dagger.Secret,
+	// +optional
+	signingIdentity string,
+	// +optional
+	textureCompression string,
+	// +optional
+	warningsAsErrors bool,
+	// +optional
+	webglCompression string,
+) (string, error) {
+	err := d.configureBuild(gameSrc, buildOptions{
+		NoLicense:          true,
+		AndroidAppBundle:   androidAppBundle,
+		Architecture:       architecture,
+		BootScene:          bootScene,
+		BuildAddressables:  buildAddressables,
+		BuildCache:         buildCache,
+		BuildMethod:        buildMethod,
+		BuildMetrics:       buildMetrics,
+		BuildName:          buildName,
+		BuildNumber:        buildNumber,
+		BuildTarget:        buildTarget,
+		BundleVersion:      bundleVersion,
+		Development:        development,
+		ExportLibrary:      exportLibrary,
+		ForceRebuild:       forceRebuild,
+		Il2cppArgs:         il2cppArgs,
+		Keystore:           keystore,
+		KeystoreAlias:      keystoreAlias,
+		KeystoreAliasPass:  keystoreAliasPass,
+		KeystorePass:       keystorePass,
+		MaxBuildSize:       maxBuildSize,
+		OutputLayout:       outputLayout,
+		PlayerSettings:     playerSettings,
+		PostBuildScript:    postBuildScript,
+		Scenes:             scenes,
+		ScriptingBackend:   scriptingBackend,
+		ServerBuild:        serverBuild,
+		SigningCert:        signingCert,
+		SigningCertPass:    signingCertPass,
+		SigningIdentity:    signingIdentity,
+		TextureCompression: textureCompression,
+		WarningsAsErrors:   warningsAsErrors,
+		WebglCompression:   webglCompression,
+	})
+
+	if err != nil {
+		return "", err
 	}
 
-	if gameciVersion != "" {
-		d.GameciVersion = gameciVersion
+	buildPath := d.buildPath("/builds/")
+	cmd := append(d.buildCommand(buildPath), d.ExtraArgs...)
+
+	return d.describeCommand(cmd, d.buildLogPath(buildPath)), nil
+}
+
+// Build the things and return only the Unity log
+//
+// The log is returned even when the build fails, as long as the editor ran.
+func (d *Dirk) BuildLog(
+	ctx context.Context,
+	gameSrc *dagger.Directory,
+	// +optional
+	androidAppBundle bool,
+	// +optional
+	architecture string,
+	// +optional
+	bootScene string,
+	// +optional
+	buildAddressables bool,
+	// +optional
+	buildMethod string,
+	// +optional
+	buildMetrics bool,
+	// +optional
+	buildName string,
+	// +optional
+	buildNumber int,
+	// +optional
+	buildTarget string,
+	// +optional
+	bundleVersion string,
+	// +optional
+	development bool,
+	// +optional
+	il2cppArgs []string,
+	// +optional
+	keystore *dagger.File,
+	// +optional
+	keystoreAlias string,
+	// +optional
+	keystoreAliasPass *dagger.Secret,
+	// +optional
+	keystorePass *dagger.Secret,
+	// +optional
+	maxBuildSize int,
+	// +optional
+	outputLayout string,
+	// +optional
+	playerSettings *dagger.File,
+	// +optional
+	postBuildScript *dagger.File,
+	// +optional
+	scenes []string,
+	// +optional
+	scriptingBackend string,
+	// +optional
+	serverBuild bool,
+	// +optional
+	signingCert *dagger.File,
+	// +optional
+	signingCertPass *dagger.Secret,
+	// +optional
+	signingIdentity string,
+	// +optional
+	textureCompression string,
+	// +optional
+	warningsAsErrors bool,
+	// +optional
+	webglCompression string,
+) (*dagger.File, error) {
+	err := d.configureBuild(gameSrc, buildOptions{
+		AndroidAppBundle:   androidAppBundle,
+		Architecture:       architecture,
+		BootScene:          bootScene,
+		BuildAddressables:  buildAddressables,
+		BuildMethod:        buildMethod,
+		BuildMetrics:       buildMetrics,
+		BuildName:          buildName,
+		BuildNumber:        buildNumber,
+		BuildTarget:        buildTarget,
+		BundleVersion:      bundleVersion,
+		Development:        development,
+		Il2cppArgs:         il2cppArgs,
+		Keystore:           keystore,
+		KeystoreAlias:      keystoreAlias,
+		KeystoreAliasPass:  keystoreAliasPass,
+		KeystorePass:       keystorePass,
+		MaxBuildSize:       maxBuildSize,
+		OutputLayout:       outputLayout,
+		PlayerSettings:     playerSettings,
+		PostBuildScript:    postBuildScript,
+		Scenes:             scenes,
+		ScriptingBackend:   scriptingBackend,
+		ServerBuild:        serverBuild,
+		SigningCert:        signingCert,
+		SigningCertPass:    signingCertPass,
+		SigningIdentity:    signingIdentity,
+		TextureCompression: textureCompression,
+		WarningsAsErrors:   warningsAsErrors,
+		WebglCompression:   webglCompression,
+	})
+
+	if err != nil {
+		return nil, err
 	}
 
-	if pass != nil {
-		d.Pass = pass
+	_, err = d.buildProject(ctx)
+
+	if d.Log == nil {
+		return nil, err
+	}
+
+	return d.Log, nil
+}
+
+// Build AssetBundles without a player build
+//
+// Bundles are built for the build target into outputPath under /bundles,
+// which defaults to the build target name.
+func (d *Dirk) BuildAssetBundles(
+	ctx context.Context,
+	gameSrc *dagger.Directory,
+	// +optional
+	buildTarget string,
+	// +optional
+	outputPath string,
+) (*dagger.Directory, error) {
+	err := d.configureBuild(gameSrc, buildOptions{
+		BuildTarget: buildTarget,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if d.BuildTarget == "" {
+		return nil, fmt.Errorf("no build target provided")
+	}
+
+	if outputPath == "" {
+		outputPath = d.BuildTarget
+	}
+
+	outputPath = path.Clean(outputPath)
+
+	if path.IsAbs(outputPath) || outputPath == ".." || strings.HasPrefix(outputPath, "../") {
+		return nil, fmt.Errorf("invalid output path %q: must be relative to /bundles", outputPath)
+	}
+
+	c, err := d.createBuildContainer(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		d.releaseLicense(ctx, c)
+	}()
+
+	built, err := d.runEditorMethod(ctx, c, "BuildCommand.BuildAssetBundles", "/bundles/"+outputPath+"/", d.logPath("/bundles/unity.log", ""), "asset bundle build")
+
+	if err != nil {
+		return nil, err
+	}
+
+	c = built
+
+	return c.Directory("/bundles"), nil
+}
+
+// Check the project compiles without building
+//
+// The editor opens the project, which recompiles its scripts, and quits. Any
+// compilation errors fail the run and are listed in the error.
+func (d *Dirk) Compile(
+	ctx context.Context,
+	gameSrc *dagger.Directory,
+	// +optional
+	buildTarget string,
+) (string, error) {
+	err := d.configureBuild(gameSrc, buildOptions{
+		BuildTarget: buildTarget,
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	c, err := d.createBuildContainer(ctx)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer func() {
+		d.releaseLicense(ctx, c)
+	}()
+
+	logFile := d.logPath("/compile/unity.log", "")
+
+	cmd := append(d.baseCommand(), "-projectPath", "/src", "-quit")
+
+	if d.BuildTarget != "" {
+		cmd = append(cmd, "-buildTarget", d.BuildTarget)
+	}
+
+	cmd = d.withLogFile(cmd, logFile)
+
+	compiled, err := d.runStep(ctx, c.WithExec(cmd,
+		dagger.ContainerWithExecOpts{
+			Expect: dagger.ReturnTypeAny,
+		},
+	), "compile")
+
+	if err != nil {
+		return "", err
+	}
+
+	c = compiled
+
+	d.Log = c.File(logFile)
+
+	log, err := d.Log.Contents(ctx)
+
+	if err != nil {
+		return "", fmt.Errorf("could not read %s: %w", logFile, err)
+	}
+
+	if errs := compilerErrors(log); len(errs) > 0 {
+		return "", fmt.Errorf("%d script compilation error(s):\n%s", len(errs), strings.Join(errs, "\n"))
+	}
+
+	if err := d.checkForError(ctx, c, logFile); err != nil {
+		return "", err
+	}
+
+	exitCode, err := c.ExitCode(ctx)
+
+	if err != nil {
+		return "", err
+	}
+
+	if exitCode != 0 {
+		return "", fmt.Errorf("unity exited with code %d, check %s", exitCode, logFile)
+	}
+
+	return "Scripts compiled without errors\n", nil
+}
+
+// Build several targets sequentially, one subdirectory per target
+//
+// All targets share the same container and Library cache so assets are only
+// imported once. The GameCI image selected by platform must include the
+// modules for every requested target.
+func (d *Dirk) BuildMatrix(
+	ctx context.Context,
+	gameSrc *dagger.Directory,
+	// +optional
+	buildTargets []string,
+	// +optional
+	androidAppBundle bool,
+	// +optional
+	architecture string,
+	// +optional
+	bootScene string,
+	// +optional
+	buildAddressables bool,
+	// +optional
+	buildMethod string,
+	// +optional
+	buildMetrics bool,
+	// +optional
+	buildName string,
+	// +optional
+	buildNumber int,
+	// +optional
+	bundleVersion string,
+	// +optional
+	development bool,
+	// +optional
+	il2cppArgs []string,
+	// +optional
+	keystore *dagger.File,
+	// +optional
+	keystoreAlias string,
+	// +optional
+	keystoreAliasPass *dagger.Secret,
+	// +optional
+	keystorePass *dagger.Secret,
+	// +optional
+	maxBuildSize int,
+	// +optional
+	outputLayout string,
+	// +optional
+	playerSettings *dagger.File,
+	// +optional
+	postBuildScript *dagger.File,
+	// +optional
+	scenes []string,
+	// +optional
+	scriptingBackend string,
+	// +optional
+	serverBuild bool,
+	// +optional
+	signingCert *dagger.File,
+	// +optional
+	signingCertPass *dagger.Secret,
+	// +optional
+	signingIdentity string,
+	// +optional
+	textureCompression string,
+	// +optional
+	warningsAsErrors bool,
+	// +optional
+	webglCompression string,
+) (*dagger.Directory, error) {
+	err := d.configureBuild(gameSrc, buildOptions{
+		AndroidAppBundle:   androidAppBundle,
+		Architecture:       architecture,
+		BootScene:          bootScene,
+		BuildAddressables:  buildAddressables,
+		BuildMethod:        buildMethod,
+		BuildMetrics:       buildMetrics,
+		BuildName:          buildName,
+		BuildNumber:        buildNumber,
+		BundleVersion:      bundleVersion,
+		Development:        development,
+		Il2cppArgs:         il2cppArgs,
+		Keystore:           keystore,
+		KeystoreAlias:      keystoreAlias,
+		KeystoreAliasPass:  keystoreAliasPass,
+		KeystorePass:       keystorePass,
+		MaxBuildSize:       maxBuildSize,
+		OutputLayout:       outputLayout,
+		PlayerSettings:     playerSettings,
+		PostBuildScript:    postBuildScript,
+		Scenes:             scenes,
+		ScriptingBackend:   scriptingBackend,
+		ServerBuild:        serverBuild,
+		SigningCert:        signingCert,
+		SigningCertPass:    signingCertPass,
+		SigningIdentity:    signingIdentity,
+		TextureCompression: textureCompression,
+		WarningsAsErrors:   warningsAsErrors,
+		WebglCompression:   webglCompression,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(buildTargets) == 0 && d.BuildTarget != "" {
+		buildTargets = []string{d.BuildTarget}
+	}
+
+	if len(buildTargets) == 0 {
+		return nil, fmt.Errorf("no build targets provided")
+	}
+
+	for _, target := range buildTargets {
+		if err := checkScriptingBackend(target, d.ScriptingBackend); err != nil {
+			return nil, fmt.Errorf("%s: %w", target, err)
+		}
+
+		if err := d.checkServerBuild(target); err != nil {
+			return nil, fmt.Errorf("%s: %w", target, err)
+		}
+
+		if err := d.checkArchitecture(target); err != nil {
+			return nil, fmt.Errorf("%s: %w", target, err)
+		}
+	}
+
+	if err := d.checkMacSigning(ctx, buildTargets); err != nil {
+		return nil, err
+	}
+
+	c, err := d.createBuildContainer(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		d.releaseLicense(ctx, c)
+	}()
+
+	builds := dag.Directory()
+
+	for _, target := range buildTargets {
+		fmt.Println("Building " + target)
+
+		d.BuildTarget = target
+		buildPath := d.buildPath("/builds/" + target + "/")
+
+		built, err := d.build(ctx, c, buildPath)
+
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", target, err)
+		}
+
+		c = built
+
+		finished, err := d.finishBuild(ctx, c, buildPath)
+
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", target, err)
+		}
+
+		c = finished
+
+		builds = builds.WithDirectory(target, c.Directory("/builds/"+target))
+	}
+
+	return d.withOutputName(builds), nil
+}
+
+// dryRun assembles the editor container and mounts the source without
+// activating a license or running the editor, and reports what it resolved
+func (d *Dirk) dryRun(ctx context.Context) (*dagger.Directory, error) {
+	fmt.Println("Dry run, the editor will not run")
+
+	c, err := d.createBaseImage()
+
+	if err != nil {
+		return nil, err
+	}
+
+	image, err := d.image()
+
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := c.WithDirectory("/src", d.Src).Directory("/src").Entries(ctx)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not mount the source: %w", err)
+	}
+
+	summary := fmt.Sprintf("image: %s\nunity version: %s\nbuild target: %s\nsource mounted: %d entries\n", image, d.UnityVersion, d.BuildTarget, len(entries))
+
+	fmt.Print(summary)
+
+	return dag.Directory().WithNewFile("dry-run.txt", summary), nil
+}
+
+// withSecrets adds the secrets of the dotenv file name in the project, when
+// it has one, as secret env vars
+func (d *Dirk) withSecrets(ctx context.Context, c *dagger.Container, name string) (*dagger.Container, error) {
+	f, err := dotenvFile(ctx, d.Src, name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if f == nil {
+		return c, nil
+	}
+
+	c, err = NewEnv().Container(ctx, f, c, true)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not load %s: %w", name, err)
+	}
+
+	return c, nil
+}
+
+// createBuildContainer creates the licensed editor container with the
+// project source and Library cache mounted
+func (d *Dirk) createBuildContainer(ctx context.Context) (*dagger.Container, error) {
+	c, err := d.createBaseImage()
+
+	if err != nil {
+		return nil, err
+	}
+
+	c, err = d.withSecrets(ctx, c, "unity_secrets.env")
+
+	if err != nil {
+		return nil, err
+	}
+
+	c = d.withLibrary(c.WithDirectory("/src", d.Src))
+
+	c, err = d.runPreBuildScript(ctx, c)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c, err = d.register(ctx, c)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// UPM global package cache of the root user in GameCI images
+const packageCachePath = "/root/.config/unity3d/cache"
+
+// Static method executed by Unity when no build method is provided
+const defaultBuildMethod = "BuildCommand.PerformBuild"
+
+// editorOptions holds the constructor arguments shared by every function
+// running the editor, named after the settings they override. Zero values
+// keep the setting from the environment or dotenv.
+type editorOptions struct {
+	Accelerator          string
+	AcceleratorNamespace string
+	ActivationRetries    int
+	CacheKey             string
+	Defines              []string
+	Deterministic        bool
+	ExtraArgs            []string
+	Fast                 bool
+	GameciVersion        string
+	Graphics             bool
+	GraphicsApi          string
+	HttpProxy            string
+	HttpsProxy           string
+	KeepLicense          bool
+	LibrarySeed          *dagger.Directory
+	LicensingVerbose     bool
+	LogPath              string
+	NoCache              bool
+	NoLibraryCache       bool
+	NoProxy              string
+	OutputName           string
+	PackageCacheKey      string
+	PackagesManifest     *dagger.File
+	Pass                 *dagger.Secret
+	Platform             string
+	PlatformArch         string
+	PreBuildScript       *dagger.File
+	Registry             string
+	RegistryPass         *dagger.Secret
+	RegistryUser         string
+	ResolvConf           *dagger.File
+	ScreenDepth          int
+	ScreenHeight         int
+	Screens              []string
+	ScreenWidth          int
+	Serial               *dagger.Secret
+	ServiceConfig        *dagger.File
+	TargetOs             string
+	Timeout              int
+	Ulf                  *dagger.File
+	UlfDir               *dagger.Directory
+	UnityVersion         string
+	User                 string
+	Verbose              bool
+}
+
+// editorArgs snapshots the settings given to the constructor, before
+// configureEditor overwrites them with the ones resolved from the
+// environment and dotenvs
+func (d *Dirk) editorArgs() editorOptions {
+	return editorOptions{
+		Accelerator:          d.Accelerator,
+		AcceleratorNamespace: d.AcceleratorNamespace,
+		ActivationRetries:    d.ActivationRetries,
+		CacheKey:             d.CacheKey,
+		Defines:              d.Defines,
+		Deterministic:        d.Deterministic,
+		ExtraArgs:            d.ExtraArgs,
+		Fast:                 d.Fast,
+		GameciVersion:        d.GameciVersion,
+		Graphics:             d.Graphics,
+		GraphicsApi:          d.GraphicsApi,
+		HttpProxy:            d.HttpProxy,
+		HttpsProxy:           d.HttpsProxy,
+		KeepLicense:          d.KeepLicense,
+		LibrarySeed:          d.LibrarySeed,
+		LicensingVerbose:     d.LicensingVerbose,
+		LogPath:              d.LogPath,
+		NoCache:              d.NoCache,
+		NoLibraryCache:       d.NoLibraryCache,
+		NoProxy:              d.NoProxy,
+		OutputName:           d.OutputName,
+		PackageCacheKey:      d.PackageCacheKey,
+		PackagesManifest:     d.PackagesManifest,
+		Pass:                 d.Pass,
+		Platform:             d.Platform,
+		PlatformArch:         d.PlatformArch,
+		PreBuildScript:       d.PreBuildScript,
+		Registry:             d.Registry,
+		RegistryPass:         d.RegistryPass,
+		RegistryUser:         d.RegistryUser,
+		ResolvConf:           d.ResolvConf,
+		ScreenDepth:          d.ScreenDepth,
+		ScreenHeight:         d.ScreenHeight,
+		Screens:              d.Screens,
+		ScreenWidth:          d.ScreenWidth,
+		Serial:               d.Serial,
+		ServiceConfig:        d.ServiceConfig,
+		TargetOs:             d.Os,
+		Timeout:              d.Timeout,
+		Ulf:                  d.Ulf,
+		UlfDir:               d.UlfDir,
+		UnityVersion:         d.UnityVersion,
+		User:                 d.User,
+		Verbose:              d.Verbose,
+	}
+}
+
+// buildOptions holds the arguments of the build functions
+type buildOptions struct {
+	AndroidAppBundle   bool
+	Architecture       string
+	BootScene          string
+	BuildAddressables  bool
+	BuildCache         bool
+	BuildMethod        string
+	BuildMetrics       bool
+	BuildName          string
+	BuildNumber        int
+	BuildTarget        string
+	BundleVersion      string
+	Development        bool
+	DryRun             bool
+	ExportLibrary      bool
+	ForceRebuild       bool
+	Il2cppArgs         []string
+	Keystore           *dagger.File
+	KeystoreAlias      string
+	KeystoreAliasPass  *dagger.Secret
+	KeystorePass       *dagger.Secret
+	MaxBuildSize       int
+	NoLicense          bool // Skip the license checks, for functions that never start the editor
+	OutputLayout       string
+	PlayerSettings     *dagger.File
+	PostBuildScript    *dagger.File
+	Scenes             []string
+	ScriptingBackend   string
+	ServerBuild        bool
+	SigningCert        *dagger.File
+	SigningCertPass    *dagger.Secret
+	SigningIdentity    string
+	TextureCompression string
+	WarningsAsErrors   bool
+	WebglCompression   string
+}
+
+// testOptions holds the arguments of the test functions
+type testOptions struct {
+	Cobertura                 bool
+	Coverage                  bool
+	CoverageAdditionalMetrics bool
+	CoverageAssemblyFilters   string
+	CoverageBadgeReport       bool
+	CoverageHistory           *dagger.Directory
+	CoverageHistoryPath       string
+	CoverageHtmlReport        bool
+	CoverageHtmlReportHistory bool
+	CoveragePathFilters       string
+	CoverageResultsPath       string
+	CoverageVerbosity         string
+	IncludeProject            bool
+	Junit                     bool
+	JunitTransform            *dagger.File
+	MinCoverage               float64
+	NoLicense                 bool // Skip the license checks, for functions that never start the editor
+	ResultsName               string
+	RetryFailed               int
+	SaxonImage                string
+	TestAssembly              string
+	TestAssemblyNames         []string
+	TestCategory              string
+	TestingingPlatform        string
+}
+
+// configureEditor resolves the settings shared by every editor run from the
+// environment, the given dotenvs and the constructor arguments, in that
+// order. Later dotenvs win over earlier ones.
+func (d *Dirk) configureEditor(gameSrc *dagger.Directory, dotenvs ...string) error {
+	o := d.editorArgs()
+
+	gameSrc = gameSrc.WithoutDirectory(".git")
+	gameSrc = gameSrc.WithoutDirectory(".dagger")
+	gameSrc = gameSrc.WithoutDirectory(".vscode")
+	gameSrc = gameSrc.WithoutFiles([]string{".gitignore", ".gitmodules", ".DS_Store", "dagger.json", "go.work", "LICENSE", "README.md"})
+
+	d.Src = gameSrc
+
+	for _, dotenv := range dotenvs {
+		f, err := dotenvFile(context.Background(), gameSrc, dotenv)
+
+		if err != nil {
+			return err
+		}
+
+		if f == nil {
+			continue
+		}
+
+		if err := NewEnv().Host(context.Background(), f); err != nil {
+			return fmt.Errorf("could not load %s: %w", dotenv, err)
+		}
+	}
+
+	d.Accelerator = os.Getenv("DIRK_ACCELERATOR")
+	d.AcceleratorNamespace = os.Getenv("DIRK_ACCELERATOR_NAMESPACE")
+
+	if err := lookupEnvInt("DIRK_ACTIVATION_RETRIES", &d.ActivationRetries); err != nil {
+		return err
+	}
+
+	d.CacheKey = os.Getenv("DIRK_CACHE_KEY")
+
+	if _, b := os.LookupEnv("DIRK_DEFINES"); b {
+		d.Defines = nonEmptyDefines(strings.Split(os.Getenv("DIRK_DEFINES"), ","))
+	}
+
+	d.Deterministic, _ = strconv.ParseBool(os.Getenv("DIRK_DETERMINISTIC"))
+	d.ExtraArgs = strings.Fields(os.Getenv("DIRK_EXTRA_ARGS"))
+	d.Fast, _ = strconv.ParseBool(os.Getenv("DIRK_FAST"))
+	d.GameciVersion = os.Getenv("DIRK_GAMECI_VERSION")
+	d.Graphics, _ = strconv.ParseBool(os.Getenv("DIRK_GRAPHICS"))
+	d.GraphicsApi = os.Getenv("DIRK_GRAPHICS_API")
+	d.HttpProxy = os.Getenv("DIRK_HTTP_PROXY")
+	d.HttpsProxy = os.Getenv("DIRK_HTTPS_PROXY")
+	d.KeepLicense, _ = strconv.ParseBool(os.Getenv("DIRK_KEEP_LICENSE"))
+
+	if _, b := os.LookupEnv("DIRK_LIBRARY_SEED"); b {
+		d.LibrarySeed = gameSrc.Directory(os.Getenv("DIRK_LIBRARY_SEED"))
+	}
+
+	d.LicensingVerbose, _ = strconv.ParseBool(os.Getenv("DIRK_LICENSING_VERBOSE"))
+	d.LogPath = os.Getenv("DIRK_LOG_PATH")
+	d.NoCache, _ = strconv.ParseBool(os.Getenv("DIRK_NO_CACHE"))
+	d.NoProxy = os.Getenv("DIRK_NO_PROXY")
+	d.NoLibraryCache, _ = strconv.ParseBool(os.Getenv("DIRK_NO_LIBRARY_CACHE"))
+	d.Os = os.Getenv("DIRK_OS")
+
+	if _, b := os.LookupEnv("DIRK_PASS"); b {
+		d.Pass = dag.Secret(os.Getenv("DIRK_PASS"))
+	}
+
+	d.OutputName = os.Getenv("DIRK_OUTPUT_NAME")
+	d.PackageCacheKey = os.Getenv("DIRK_PACKAGE_CACHE_KEY")
+
+	if _, b := os.LookupEnv("DIRK_PACKAGES_MANIFEST"); b {
+		d.PackagesManifest = gameSrc.File(os.Getenv("DIRK_PACKAGES_MANIFEST"))
+	}
+
+	d.Platform = os.Getenv("DIRK_PLATFORM")
+	d.PlatformArch = os.Getenv("DIRK_PLATFORM_ARCH")
+
+	if _, b := os.LookupEnv("DIRK_PRE_BUILD_SCRIPT"); b {
+		d.PreBuildScript = gameSrc.File(os.Getenv("DIRK_PRE_BUILD_SCRIPT"))
+	}
+
+	d.Registry = os.Getenv("DIRK_REGISTRY")
+
+	if _, b := os.LookupEnv("DIRK_REGISTRY_PASS"); b {
+		d.RegistryPass = dag.SetSecret("DIRK_REGISTRY_PASS", os.Getenv("DIRK_REGISTRY_PASS"))
+	}
+
+	d.RegistryUser = os.Getenv("DIRK_REGISTRY_USER")
+
+	if _, b := os.LookupEnv("DIRK_RESOLV_CONF"); b {
+		d.ResolvConf = gameSrc.File(os.Getenv("DIRK_RESOLV_CONF"))
+	}
+
+	for env, value := range map[string]*int{
+		"DIRK_SCREEN_DEPTH":  &d.ScreenDepth,
+		"DIRK_SCREEN_HEIGHT": &d.ScreenHeight,
+		"DIRK_SCREEN_WIDTH":  &d.ScreenWidth,
+	} {
+		if err := lookupEnvInt(env, value); err != nil {
+			return err
+		}
+	}
+
+	if _, b := os.LookupEnv("DIRK_SCREENS"); b {
+		d.Screens = strings.Split(os.Getenv("DIRK_SCREENS"), ",")
+	}
+
+	if _, b := os.LookupEnv("DIRK_SERIAL"); b {
+		d.Serial = dag.Secret(os.Getenv("DIRK_SERIAL"))
+	}
+
+	if _, b := os.LookupEnv("DIRK_SERVICE_CONFIG"); b {
+		d.ServiceConfig = gameSrc.File(os.Getenv("DIRK_SERVICE_CONFIG"))
+	}
+
+	if err := lookupEnvInt("DIRK_TIMEOUT", &d.Timeout); err != nil {
+		return err
+	}
+
+	if _, b := os.LookupEnv("DIRK_ULF"); b {
+		d.Ulf = gameSrc.File(os.Getenv("DIRK_ULF"))
+	}
+
+	if _, b := os.LookupEnv("DIRK_ULF_DIR"); b {
+		d.UlfDir = gameSrc.Directory(os.Getenv("DIRK_ULF_DIR"))
+	}
+
+	if _, b := os.LookupEnv("DIRK_UNITY_VERSION"); b {
+		d.UnityVersion = os.Getenv("DIRK_UNITY_VERSION")
+	}
+
+	d.User = os.Getenv("DIRK_USER")
+	d.Verbose, _ = strconv.ParseBool(os.Getenv("DIRK_VERBOSE"))
+
+	if o.Accelerator != "" {
+		d.Accelerator = o.Accelerator
+	}
+
+	if o.AcceleratorNamespace != "" {
+		d.AcceleratorNamespace = o.AcceleratorNamespace
+	}
+
+	if o.ActivationRetries != 0 {
+		d.ActivationRetries = o.ActivationRetries
+	}
+
+	if o.CacheKey != "" {
+		d.CacheKey = o.CacheKey
+	}
+
+	if len(o.Defines) > 0 {
+		d.Defines = nonEmptyDefines(o.Defines)
+	}
+
+	if o.Deterministic {
+		d.Deterministic = o.Deterministic
+	}
+
+	if len(o.ExtraArgs) > 0 {
+		d.ExtraArgs = o.ExtraArgs
+	}
+
+	if o.Fast {
+		d.Fast = o.Fast
+	}
+
+	if o.GameciVersion != "" {
+		d.GameciVersion = o.GameciVersion
+	}
+
+	if o.Graphics {
+		d.Graphics = o.Graphics
+	}
+
+	if o.GraphicsApi != "" {
+		d.GraphicsApi = o.GraphicsApi
+	}
+
+	if o.HttpProxy != "" {
+		d.HttpProxy = o.HttpProxy
+	}
+
+	if o.HttpsProxy != "" {
+		d.HttpsProxy = o.HttpsProxy
+	}
+
+	if o.KeepLicense {
+		d.KeepLicense = o.KeepLicense
+	}
+
+	if o.OutputName != "" {
+		d.OutputName = o.OutputName
+	}
+
+	if o.PackageCacheKey != "" {
+		d.PackageCacheKey = o.PackageCacheKey
+	}
+
+	if o.PackagesManifest != nil {
+		d.PackagesManifest = o.PackagesManifest
+	}
+
+	if o.Pass != nil {
+		d.Pass = o.Pass
+	}
+
+	if o.Platform != "" {
+		d.Platform = o.Platform
+	}
+
+	if o.PlatformArch != "" {
+		d.PlatformArch = o.PlatformArch
+	}
+
+	if o.PreBuildScript != nil {
+		d.PreBuildScript = o.PreBuildScript
+	}
+
+	if o.ResolvConf != nil {
+		d.ResolvConf = o.ResolvConf
+	}
+
+	if o.Registry != "" {
+		d.Registry = o.Registry
+	}
+
+	if o.RegistryPass != nil {
+		d.RegistryPass = o.RegistryPass
+	}
+
+	if o.RegistryUser != "" {
+		d.RegistryUser = o.RegistryUser
+	}
+
+	if o.ScreenDepth != 0 {
+		d.ScreenDepth = o.ScreenDepth
+	}
+
+	if o.ScreenHeight != 0 {
+		d.ScreenHeight = o.ScreenHeight
+	}
+
+	if o.ScreenWidth != 0 {
+		d.ScreenWidth = o.ScreenWidth
+	}
+
+	if len(o.Screens) > 0 {
+		d.Screens = o.Screens
+	}
+
+	if o.Serial != nil {
+		d.Serial = o.Serial
+	}
+
+	if o.ServiceConfig != nil {
+		d.ServiceConfig = o.ServiceConfig
+	}
+
+	if o.LibrarySeed != nil {
+		d.LibrarySeed = o.LibrarySeed
+	}
+
+	if o.LicensingVerbose {
+		d.LicensingVerbose = o.LicensingVerbose
+	}
+
+	if o.LogPath != "" {
+		d.LogPath = o.LogPath
+	}
+
+	if o.NoCache {
+		d.NoCache = o.NoCache
+	}
+
+	if o.NoProxy != "" {
+		d.NoProxy = o.NoProxy
+	}
+
+	if o.NoLibraryCache {
+		d.NoLibraryCache = o.NoLibraryCache
+	}
+
+	if o.TargetOs != "" {
+		d.Os = o.TargetOs
+	}
+
+	if o.Timeout != 0 {
+		d.Timeout = o.Timeout
+	}
+
+	if o.Ulf != nil {
+		d.Ulf = o.Ulf
+	}
+
+	if o.UlfDir != nil {
+		d.UlfDir = o.UlfDir
+	}
+
+	if o.UnityVersion != "" {
+		d.UnityVersion = o.UnityVersion
+	}
+
+	if o.User != "" {
+		d.User = o.User
+	}
+
+	if o.Verbose {
+		d.Verbose = o.Verbose
+	}
+
+	if d.ScreenWidth < 0 || d.ScreenHeight < 0 || d.ScreenDepth < 0 {
+		return fmt.Errorf("invalid screen %dx%dx%d: dimensions must be positive", d.ScreenWidth, d.ScreenHeight, d.ScreenDepth)
+	}
+
+	if d.LogPath != "" && !path.IsAbs(d.LogPath) {
+		return fmt.Errorf("invalid log path %q: expected an absolute path", d.LogPath)
+	}
+
+	if err := d.checkOutputName(); err != nil {
+		return err
+	}
+
+	if err := d.checkFast(); err != nil {
+		return err
+	}
+
+	if err := d.checkPlatformArch(); err != nil {
+		return err
+	}
+
+	if err := d.checkRegistry(); err != nil {
+		return err
+	}
+
+	if err := d.checkScreens(); err != nil {
+		return err
+	}
+
+	if err := d.checkGraphicsApi(); err != nil {
+		return err
+	}
+
+	if err := d.checkAccelerator(); err != nil {
+		return err
+	}
+
+	if err := d.resolveUnityVersion(); err != nil {
+		return err
+	}
+
+	d.Src = d.withDefines(d.Src)
+
+	if d.PackagesManifest != nil {
+		src, err := d.withPackagesManifest(d.Src)
+
+		if err != nil {
+			return err
+		}
+
+		d.Src = src
+	}
+
+	return nil
+}
+
+// checkLicensing validates the license settings ahead of the first editor
+// run, including the ULF matching the Unity version of a ULF directory
+func (d *Dirk) checkLicensing() error {
+	if err := d.checkLicense(); err != nil {
+		return err
+	}
+
+	if d.ServiceConfig != nil {
+		if err := d.checkServiceConfig(context.Background()); err != nil {
+			return err
+		}
+	}
+
+	if d.UlfDir != nil {
+		if _, err := d.personalLicense(context.Background()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// configureBuild resolves the build settings from the environment, the
+// unity.env dotenv and the constructor and given arguments, in that order
+func (d *Dirk) configureBuild(gameSrc *dagger.Directory, o buildOptions) error {
+	if err := d.configureEditor(gameSrc, "./unity.env"); err != nil {
+		return err
+	}
+
+	if err := d.configureBuildSettings(gameSrc, o); err != nil {
+		return err
+	}
+
+	if d.DryRun || o.NoLicense {
+		return nil
+	}
+
+	return d.checkLicensing()
+}
+
+// configureLicense resolves the editor settings from the environment, the
+// unity.env dotenv and the constructor arguments for a run that only
+// activates the license, so build settings are neither read nor validated
+func (d *Dirk) configureLicense(gameSrc *dagger.Directory) error {
+	if err := d.configureEditor(gameSrc, "./unity.env"); err != nil {
+		return err
+	}
+
+	return d.checkLicensing()
+}
+
+// configureBuildSettings resolves the settings only builds use, on top of the
+// shared editor settings
+func (d *Dirk) configureBuildSettings(gameSrc *dagger.Directory, o buildOptions) error {
+	d.AndroidAppBundle, _ = strconv.ParseBool(os.Getenv("DIRK_ANDROID_APP_BUNDLE"))
+	d.Architecture = os.Getenv("DIRK_ARCHITECTURE")
+	d.BuildAddressables, _ = strconv.ParseBool(os.Getenv("DIRK_BUILD_ADDRESSABLES"))
+	d.BuildCache, _ = strconv.ParseBool(os.Getenv("DIRK_BUILD_CACHE"))
+	d.BuildMetrics, _ = strconv.ParseBool(os.Getenv("DIRK_BUILD_METRICS"))
+	d.BootScene = os.Getenv("DIRK_BOOT_SCENE")
+	d.BuildMethod = os.Getenv("DIRK_BUILD_METHOD")
+	d.BuildName = os.Getenv("DIRK_BUILD_NAME")
+
+	if err := lookupEnvInt("DIRK_BUILD_NUMBER", &d.BuildNumber); err != nil {
+		return err
+	}
+
+	d.BuildTarget = os.Getenv("DIRK_BUILD_TARGET")
+	d.BundleVersion = os.Getenv("DIRK_BUNDLE_VERSION")
+	d.Development, _ = strconv.ParseBool(os.Getenv("DIRK_DEVELOPMENT"))
+	d.DryRun, _ = strconv.ParseBool(os.Getenv("DIRK_DRY_RUN"))
+	d.ExportLibrary, _ = strconv.ParseBool(os.Getenv("DIRK_EXPORT_LIBRARY"))
+	d.ForceRebuild, _ = strconv.ParseBool(os.Getenv("DIRK_FORCE_REBUILD"))
+	d.Il2cppArgs = strings.Fields(os.Getenv("DIRK_IL2CPP_ARGS"))
+
+	if _, b := os.LookupEnv("DIRK_KEYSTORE"); b {
+		d.Keystore = gameSrc.File(os.Getenv("DIRK_KEYSTORE"))
+	}
+
+	d.KeystoreAlias = os.Getenv("DIRK_KEYSTORE_ALIAS")
+
+	if _, b := os.LookupEnv("DIRK_KEYSTORE_ALIAS_PASS"); b {
+		d.KeystoreAliasPass = dag.SetSecret("DIRK_KEYSTORE_ALIAS_PASS", os.Getenv("DIRK_KEYSTORE_ALIAS_PASS"))
+	}
+
+	if _, b := os.LookupEnv("DIRK_KEYSTORE_PASS"); b {
+		d.KeystorePass = dag.SetSecret("DIRK_KEYSTORE_PASS", os.Getenv("DIRK_KEYSTORE_PASS"))
+	}
+
+	if err := lookupEnvInt("DIRK_MAX_BUILD_SIZE", &d.MaxBuildSize); err != nil {
+		return err
+	}
+
+	d.OutputLayout = os.Getenv("DIRK_OUTPUT_LAYOUT")
+
+	if _, b := os.LookupEnv("DIRK_PLAYER_SETTINGS"); b {
+		d.PlayerSettings = gameSrc.File(os.Getenv("DIRK_PLAYER_SETTINGS"))
+	}
+
+	if _, b := os.LookupEnv("DIRK_POST_BUILD_SCRIPT"); b {
+		d.PostBuildScript = gameSrc.File(os.Getenv("DIRK_POST_BUILD_SCRIPT"))
+	}
+
+	if _, b := os.LookupEnv("DIRK_SCENES"); b {
+		d.Scenes = strings.Split(os.Getenv("DIRK_SCENES"), ",")
+	}
+
+	d.ScriptingBackend = os.Getenv("DIRK_SCRIPTING_BACKEND")
+	d.ServerBuild, _ = strconv.ParseBool(os.Getenv("DIRK_SERVER_BUILD"))
+
+	if _, b := os.LookupEnv("DIRK_SIGNING_CERT"); b {
+		d.SigningCert = gameSrc.File(os.Getenv("DIRK_SIGNING_CERT"))
+	}
+
+	if _, b := os.LookupEnv("DIRK_SIGNING_CERT_PASS"); b {
+		d.SigningCertPass = dag.SetSecret("DIRK_SIGNING_CERT_PASS", os.Getenv("DIRK_SIGNING_CERT_PASS"))
+	}
+
+	d.SigningIdentity = os.Getenv("DIRK_SIGNING_IDENTITY")
+	d.TextureCompression = os.Getenv("DIRK_TEXTURE_COMPRESSION")
+	d.WarningsAsErrors, _ = strconv.ParseBool(os.Getenv("DIRK_WARNINGS_AS_ERRORS"))
+	d.WebglCompression = os.Getenv("DIRK_WEBGL_COMPRESSION")
+
+	if o.AndroidAppBundle {
+		d.AndroidAppBundle = o.AndroidAppBundle
+	}
+
+	if o.BuildAddressables {
+		d.BuildAddressables = o.BuildAddressables
+	}
+
+	if o.BuildCache {
+		d.BuildCache = o.BuildCache
+	}
+
+	if o.BuildMetrics {
+		d.BuildMetrics = o.BuildMetrics
+	}
+
+	if o.BuildMethod != "" {
+		d.BuildMethod = o.BuildMethod
+	}
+
+	if o.BuildName != "" {
+		d.BuildName = o.BuildName
+	}
+
+	if o.BuildNumber != 0 {
+		d.BuildNumber = o.BuildNumber
+	}
+
+	if o.BuildTarget != "" {
+		d.BuildTarget = o.BuildTarget
+	}
+
+	if o.BundleVersion != "" {
+		d.BundleVersion = o.BundleVersion
+	}
+
+	if o.Development {
+		d.Development = o.Development
+	}
+
+	if o.DryRun {
+		d.DryRun = o.DryRun
+	}
+
+	if o.ExportLibrary {
+		d.ExportLibrary = o.ExportLibrary
+	}
+
+	if o.ForceRebuild {
+		d.ForceRebuild = o.ForceRebuild
+	}
+
+	if len(o.Il2cppArgs) > 0 {
+		d.Il2cppArgs = o.Il2cppArgs
+	}
+
+	if o.Keystore != nil {
+		d.Keystore = o.Keystore
+	}
+
+	if o.KeystoreAlias != "" {
+		d.KeystoreAlias = o.KeystoreAlias
+	}
+
+	if o.KeystoreAliasPass != nil {
+		d.KeystoreAliasPass = o.KeystoreAliasPass
+	}
+
+	if o.KeystorePass != nil {
+		d.KeystorePass = o.KeystorePass
+	}
+
+	if o.OutputLayout != "" {
+		d.OutputLayout = o.OutputLayout
+	}
+
+	if o.PlayerSettings != nil {
+		d.PlayerSettings = o.PlayerSettings
+	}
+
+	if o.PostBuildScript != nil {
+		d.PostBuildScript = o.PostBuildScript
+	}
+
+	if o.ServerBuild {
+		d.ServerBuild = o.ServerBuild
+	}
+
+	if o.MaxBuildSize != 0 {
+		d.MaxBuildSize = o.MaxBuildSize
+	}
+
+	if o.TextureCompression != "" {
+		d.TextureCompression = o.TextureCompression
+	}
+
+	if o.SigningCert != nil {
+		d.SigningCert = o.SigningCert
+	}
+
+	if o.SigningCertPass != nil {
+		d.SigningCertPass = o.SigningCertPass
+	}
+
+	if o.SigningIdentity != "" {
+		d.SigningIdentity = o.SigningIdentity
+	}
+
+	if o.Architecture != "" {
+		d.Architecture = o.Architecture
+	}
+
+	if o.BootScene != "" {
+		d.BootScene = o.BootScene
+	}
+
+	if len(o.Scenes) > 0 {
+		d.Scenes = o.Scenes
+	}
+
+	if o.ScriptingBackend != "" {
+		d.ScriptingBackend = o.ScriptingBackend
+	}
+
+	if o.WarningsAsErrors {
+		d.WarningsAsErrors = o.WarningsAsErrors
+	}
+
+	if o.WebglCompression != "" {
+		d.WebglCompression = o.WebglCompression
+	}
+
+	switch d.WebglCompression {
+	case "", "gzip", "brotli", "disabled":
+	default:
+		return fmt.Errorf("invalid WebGL compression %q: expected gzip, brotli or disabled", d.WebglCompression)
+	}
+
+	if err := checkScriptingBackend(d.BuildTarget, d.ScriptingBackend); err != nil {
+		return err
+	}
+
+	if len(d.Il2cppArgs) > 0 && d.ScriptingBackend == "mono2x" {
+		fmt.Println("Warning: IL2CPP arguments have no effect with the mono2x scripting backend")
+	}
+
+	if err := checkTextureCompression(d.TextureCompression); err != nil {
+		return err
+	}
+
+	for _, scene := range d.Scenes {
+		if !strings.HasPrefix(scene, "Assets/") {
+			return fmt.Errorf("invalid scene %q: scene paths must start with Assets/", scene)
+		}
+	}
+
+	if (d.SigningCert != nil || d.SigningCertPass != nil) && d.SigningIdentity == "" {
+		return fmt.Errorf("a signing certificate requires a signing identity")
+	}
+
+	if d.SigningIdentity != "" && d.SigningCert == nil {
+		return fmt.Errorf("a signing identity requires a signing certificate")
+	}
+
+	switch d.OutputLayout {
+	case "", "flat":
+	case "nested":
+		if d.BuildName == "" {
+			return fmt.Errorf("the nested output layout requires a build name")
+		}
+	default:
+		return fmt.Errorf("invalid output layout %q: expected flat or nested", d.OutputLayout)
+	}
+
+	if err := checkScopedDefines(d.Defines); err != nil {
+		return err
+	}
+
+	if d.BuildNumber < 0 {
+		return fmt.Errorf("invalid build number %d: must not be negative", d.BuildNumber)
+	}
+
+	if d.MaxBuildSize < 0 {
+		return fmt.Errorf("invalid max build size %d: expected a size in MB", d.MaxBuildSize)
+	}
+
+	if d.ExportLibrary && d.BuildCache {
+		return fmt.Errorf("exporting the Library can't be combined with the build cache, which skips the editor")
+	}
+
+	if err := d.checkServerBuild(d.BuildTarget); err != nil {
+		return err
+	}
+
+	if err := d.checkArchitecture(d.BuildTarget); err != nil {
+		return err
+	}
+
+	if d.BootScene != "" {
+		if err := d.checkBootScene(context.Background()); err != nil {
+			return err
+		}
+	}
+
+	if d.PlayerSettings != nil {
+		if err := d.checkPlayerSettings(context.Background()); err != nil {
+			return err
+		}
+	}
+
+	if d.BuildMethod == "" {
+		d.BuildMethod = defaultBuildMethod
+	}
+
+	i := strings.LastIndex(d.BuildMethod, ".")
+
+	if i <= 0 || i == len(d.BuildMethod)-1 {
+		return fmt.Errorf("invalid build method %q: expected Type.Method", d.BuildMethod)
+	}
+
+	return nil
+}
+
+// Test the things
+func (d *Dirk) Test(
+	ctx context.Context,
+	gameSrc *dagger.Directory,
+	// +optional
+	cobertura bool,
+	// +default=true
+	coverage bool,
+	// +default=true
+	// +optional
+	coverageAdditionalMetrics bool,
+	// +optional
+	coverageAssemblyFilters string,
+	// +default=true
+	// +optional
+	coverageBadgeReport bool,
+	// +optional
+	coverageHistory *dagger.Directory,
+	// +optional
+	coverageHistoryPath string,
+	// +default=true
+	// +optional
+	coverageHtmlReport bool,
+	// +default=true
+	// +optional
+	coverageHtmlReportHistory bool,
+	// +optional
+	coveragePathFilters string,
+	// +optional
+	coverageResultsPath string,
+	// +optional
+	coverageVerbosity string,
+	// +optional
+	includeProject bool,
+	// +optional
+	junit bool,
+	// +optional
+	junitTransform *dagger.File,
+	// +optional
+	minCoverage float64,
+	// +optional
+	resultsName string,
+	// +optional
+	retryFailed int,
+	// +optional
+	saxonImage string,
+	// +optional
+	testAssembly string,
+	// +optional
+	testAssemblyNames []string,
+	// +optional
+	testCategory string,
+	// +optional
+	testingingPlatform string,
+) (*dagger.Directory, error) {
+	err := d.configureTest(gameSrc, testOptions{
+		Cobertura:                 cobertura,
+		Coverage:                  coverage,
+		CoverageAdditionalMetrics: coverageAdditionalMetrics,
+		CoverageAssemblyFilters:   coverageAssemblyFilters,
+		CoverageBadgeReport:       coverageBadgeReport,
+		CoverageHistory:           coverageHistory,
+		CoverageHistoryPath:       coverageHistoryPath,
+		CoverageHtmlReport:        coverageHtmlReport,
+		CoverageHtmlReportHistory: coverageHtmlReportHistory,
+		CoveragePathFilters:       coveragePathFilters,
+		CoverageResultsPath:       coverageResultsPath,
+		CoverageVerbosity:         coverageVerbosity,
+		IncludeProject:            includeProject,
+		Junit:                     junit,
+		JunitTransform:            junitTransform,
+		MinCoverage:               minCoverage,
+		ResultsName:               resultsName,
+		RetryFailed:               retryFailed,
+		SaxonImage:                saxonImage,
+		TestAssembly:              testAssembly,
+		TestAssemblyNames:         testAssemblyNames,
+		TestCategory:              testCategory,
+		TestingingPlatform:        testingingPlatform,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	results, err := d.testProject(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return d.withOutputName(results), nil
+}
+
+// testProject tests the configured project and returns the results
+func (d *Dirk) testProject(ctx context.Context) (*dagger.Directory, error) {
+	c, err := d.createTestContainer(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		d.releaseLicense(ctx, c)
+	}()
+
+	logPath := d.logPath("/results/unity.log", "")
+
+	tested, err := d.runTests(ctx, c, logPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c = d.withTestLog(tested, logPath, "unity.log")
+
+	results := d.getTestResults(c)
+
+	if d.IncludeProject {
+		results = results.WithDirectory("project", d.getProject(c))
+	}
+
+	return results, nil
+}
+
+// Return the editor command test would run, without running it
+//
+// Credentials are redacted. Scripting defines are written to Assets/csc.rsp
+// rather than passed on the command line, so they are listed after it.
+func (d *Dirk) TestCommand(
+	gameSrc *dagger.Directory,
+	// +optional
+	cobertura bool,
+	// +default=true
+	coverage bool,
+	// +default=true
+	// +optional
+	coverageAdditionalMetrics bool,
+	// +optional
+	coverageAssemblyFilters string,
+	// +default=true
+	// +optional
+	coverageBadgeReport bool,
+	// +optional
+	coverageHistory *dagger.Directory,
+	// +optional
+	coverageHistoryPath string,
+	// +default=true
+	// +optional
+	coverageHtmlReport bool,
+	// +default=true
+	// +optional
+	coverageHtmlReportHistory bool,
+	// +optional
+	coveragePathFilters string,
+	// +optional
+	coverageResultsPath string,
+	// +optional
+	coverageVerbosity string,
+	// +optional
+	includeProject bool,
+	// +optional
+	junit bool,
+	// +optional
+	junitTransform *dagger.File,
+	// +optional
+	minCoverage float64,
+	// +optional
+	resultsName string,
+	// +optional
+	retryFailed int,
+	// +optional
+	saxonImage string,
+	// +optional
+	testAssembly string,
+	// +optional
+	testAssemblyNames []string,
+	// +optional
+	testCategory string,
+	// +optional
+	testingingPlatform string,
+) (string, error) {
+	err := d.configureTest(gameSrc, testOptions{
+		NoLicense:                 true,
+		Cobertura:                 cobertura,
+		Coverage:                  coverage,
+		CoverageAdditionalMetrics: coverageAdditionalMetrics,
+		CoverageAssemblyFilters:   coverageAssemblyFilters,
+		CoverageBadgeReport:       coverageBadgeReport,
+		CoverageHistory:           coverageHistory,
+		CoverageHistoryPath:       coverageHistoryPath,
+		CoverageHtmlReport:        coverageHtmlReport,
+		CoverageHtmlReportHistory: coverageHtmlReportHistory,
+		CoveragePathFilters:       coveragePathFilters,
+		CoverageResultsPath:       coverageResultsPath,
+		CoverageVerbosity:         coverageVerbosity,
+		IncludeProject:            includeProject,
+		Junit:                     junit,
+		JunitTransform:            junitTransform,
+		MinCoverage:               minCoverage,
+		ResultsName:               resultsName,
+		RetryFailed:               retryFailed,
+		SaxonImage:                saxonImage,
+		TestAssembly:              testAssembly,
+		TestAssemblyNames:         testAssemblyNames,
+		TestCategory:              testCategory,
+		TestingingPlatform:        testingingPlatform,
+	})
+
+	if err != nil {
+		return "", err
+	}
+
+	cmd := append(d.runTestsCommand(), d.ExtraArgs...)
+
+	return d.describeCommand(cmd, d.logPath("/results/unity.log", "")), nil
+}
+
+// Test the things and return only the Unity log
+//
+// The log is returned even when the tests fail, as long as the editor ran.
+func (d *Dirk) TestLog(
+	ctx context.Context,
+	gameSrc *dagger.Directory,
+	// +optional
+	cobertura bool,
+	// +default=true
+	coverage bool,
+	// +default=true
+	// +optional
+	coverageAdditionalMetrics bool,
+	// +optional
+	coverageAssemblyFilters string,
+	// +default=true
+	// +optional
+	coverageBadgeReport bool,
+	// +optional
+	coverageHistory *dagger.Directory,
+	// +optional
+	coverageHistoryPath string,
+	// +default=true
+	// +optional
+	coverageHtmlReport bool,
+	// +default=true
+	// +optional
+	coverageHtmlReportHistory bool,
+	// +optional
+	coveragePathFilters string,
+	// +optional
+	coverageResultsPath string,
+	// +optional
+	coverageVerbosity string,
+	// +optional
+	junit bool,
+	// +optional
+	junitTransform *dagger.File,
+	// +optional
+	minCoverage float64,
+	// +optional
+	resultsName string,
+	// +optional
+	retryFailed int,
+	// +optional
+	saxonImage string,
+	// +optional
+	testAssembly string,
+	// +optional
+	testAssemblyNames []string,
+	// +optional
+	testCategory string,
+	// +optional
+	testingingPlatform string,
+) (*dagger.File, error) {
+	err := d.configureTest(gameSrc, testOptions{
+		Cobertura:                 cobertura,
+		Coverage:                  coverage,
+		CoverageAdditionalMetrics: coverageAdditionalMetrics,
+		CoverageAssemblyFilters:   coverageAssemblyFilters,
+		CoverageBadgeReport:       coverageBadgeReport,
+		CoverageHistory:           coverageHistory,
+		CoverageHistoryPath:       coverageHistoryPath,
+		CoverageHtmlReport:        coverageHtmlReport,
+		CoverageHtmlReportHistory: coverageHtmlReportHistory,
+		CoveragePathFilters:       coveragePathFilters,
+		CoverageResultsPath:       coverageResultsPath,
+		CoverageVerbosity:         coverageVerbosity,
+		Junit:                     junit,
+		JunitTransform:            junitTransform,
+		MinCoverage:               minCoverage,
+		ResultsName:               resultsName,
+		RetryFailed:               retryFailed,
+		SaxonImage:                saxonImage,
+		TestAssembly:              testAssembly,
+		TestAssemblyNames:         testAssemblyNames,
+		TestCategory:              testCategory,
+		TestingingPlatform:        testingingPlatform,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = d.testProject(ctx)
+
+	if d.Log == nil {
+		return nil, err
+	}
+
+	return d.Log, nil
+}
+
+// Test the things and return only the HTML coverage report
+func (d *Dirk) CoverageReport(
+	ctx context.Context,
+	gameSrc *dagger.Directory,
+	// +optional
+	cobertura bool,
+	// +default=true
+	coverage bool,
+	// +default=true
+	// +optional
+	coverageAdditionalMetrics bool,
+	// +optional
+	coverageAssemblyFilters string,
+	// +default=true
+	// +optional
+	coverageBadgeReport bool,
+	// +optional
+	coverageHistory *dagger.Directory,
+	// +optional
+	coverageHistoryPath string,
+	// +default=true
+	// +optional
+	coverageHtmlReport bool,
+	// +default=true
+	// +optional
+	coverageHtmlReportHistory bool,
+	// +optional
+	coveragePathFilters string,
+	// +optional
+	coverageResultsPath string,
+	// +optional
+	coverageVerbosity string,
+	// +optional
+	junit bool,
+	// +optional
+	junitTransform *dagger.File,
+	// +optional
+	minCoverage float64,
+	// +optional
+	resultsName string,
+	// +optional
+	retryFailed int,
+	// +optional
+	saxonImage string,
+	// +optional
+	testAssembly string,
+	// +optional
+	testAssemblyNames []string,
+	// +optional
+	testCategory string,
+	// +optional
+	testingingPlatform string,
+) (*dagger.Directory, error) {
+	err := d.configureTest(gameSrc, testOptions{
+		Cobertura:                 cobertura,
+		Coverage:                  coverage,
+		CoverageAdditionalMetrics: coverageAdditionalMetrics,
+		CoverageAssemblyFilters:   coverageAssemblyFilters,
+		CoverageBadgeReport:       coverageBadgeReport,
+		CoverageHistory:           coverageHistory,
+		CoverageHistoryPath:       coverageHistoryPath,
+		CoverageHtmlReport:        coverageHtmlReport,
+		CoverageHtmlReportHistory: coverageHtmlReportHistory,
+		CoveragePathFilters:       coveragePathFilters,
+		CoverageResultsPath:       coverageResultsPath,
+		CoverageVerbosity:         coverageVerbosity,
+		Junit:                     junit,
+		JunitTransform:            junitTransform,
+		MinCoverage:               minCoverage,
+		ResultsName:               resultsName,
+		RetryFailed:               retryFailed,
+		SaxonImage:                saxonImage,
+		TestAssembly:              testAssembly,
+		TestAssemblyNames:         testAssemblyNames,
+		TestCategory:              testCategory,
+		TestingingPlatform:        testingingPlatform,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Fail before the run rather than after it if no report would be generated
+	if !d.Coverage {
+		return nil, fmt.Errorf("coverage is disabled, no report would be generated")
+	}
+
+	if !d.CoverageHtmlReport {
+		return nil, fmt.Errorf("the HTML coverage report is disabled, no report would be generated")
+	}
+
+	results, err := d.testProject(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	reportPath := strings.TrimPrefix(d.coverageResultsPath(), "/results/") + "Report"
+	report := results.Directory(reportPath)
+
+	if _, err := report.File("index.html").Sync(ctx); err != nil {
+		return nil, fmt.Errorf("no HTML coverage report found in /results/%s", reportPath)
+	}
+
+	return report, nil
+}
+
+// Test the things and return the test counts
+//
+// The counts are returned even when tests fail, as long as results were
+// written.
+func (d *Dirk) TestSummary(
+	ctx context.Context,
+	gameSrc *dagger.Directory,
+	// +optional
+	cobertura bool,
+	// +default=true
+	coverage bool,
+	// +default=true
+	// +optional
+	coverageAdditionalMetrics bool,
+	// +optional
+	coverageAssemblyFilters string,
+	// +default=true
+	// +optional
+	coverageBadgeReport bool,
+	// +optional
+	coverageHistory *dagger.Directory,
+	// +optional
+	coverageHistoryPath string,
+	// +default=true
+	// +optional
+	coverageHtmlReport bool,
+	// +default=true
+	// +optional
+	coverageHtmlReportHistory bool,
+	// +optional
+	coveragePathFilters string,
+	// +optional
+	coverageResultsPath string,
+	// +optional
+	coverageVerbosity string,
+	// +optional
+	junit bool,
+	// +optional
+	junitTransform *dagger.File,
+	// +optional
+	minCoverage float64,
+	// +optional
+	resultsName string,
+	// +optional
+	retryFailed int,
+	// +optional
+	saxonImage string,
+	// +optional
+	testAssembly string,
+	// +optional
+	testAssemblyNames []string,
+	// +optional
+	testCategory string,
+	// +optional
+	testingingPlatform string,
+) (*TestSummary, error) {
+	err := d.configureTest(gameSrc, testOptions{
+		Cobertura:                 cobertura,
+		Coverage:                  coverage,
+		CoverageAdditionalMetrics: coverageAdditionalMetrics,
+		CoverageAssemblyFilters:   coverageAssemblyFilters,
+		CoverageBadgeReport:       coverageBadgeReport,
+		CoverageHistory:           coverageHistory,
+		CoverageHistoryPath:       coverageHistoryPath,
+		CoverageHtmlReport:        coverageHtmlReport,
+		CoverageHtmlReportHistory: coverageHtmlReportHistory,
+		CoveragePathFilters:       coveragePathFilters,
+		CoverageResultsPath:       coverageResultsPath,
+		CoverageVerbosity:         coverageVerbosity,
+		Junit:                     junit,
+		JunitTransform:            junitTransform,
+		MinCoverage:               minCoverage,
+		ResultsName:               resultsName,
+		RetryFailed:               retryFailed,
+		SaxonImage:                saxonImage,
+		TestAssembly:              testAssembly,
+		TestAssemblyNames:         testAssemblyNames,
+		TestCategory:              testCategory,
+		TestingingPlatform:        testingingPlatform,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = d.testProject(ctx)
+
+	if d.Summary == nil {
+		return nil, err
+	}
+
+	return d.Summary, nil
+}
+
+// Run the EditMode and then the PlayMode tests in the same container
+//
+// Results for both platforms are merged into one directory, e.g.
+// editmode-results.xml and playmode-results.xml. With failOnTestError off,
+// failing tests are logged and the results returned instead of an error.
+func (d *Dirk) TestAll(
+	ctx context.Context,
+	gameSrc *dagger.Directory,
+	// +optional
+	cobertura bool,
+	// +default=true
+	coverage bool,
+	// +default=true
+	// +optional
+	coverageAdditionalMetrics bool,
+	// +optional
+	coverageAssemblyFilters string,
+	// +default=true
+	// +optional
+	coverageBadgeReport bool,
+	// +optional
+	coverageHistory *dagger.Directory,
+	// +default=true
+	// +optional
+	coverageHtmlReport bool,
+	// +default=true
+	// +optional
+	coverageHtmlReportHistory bool,
+	// +optional
+	coveragePathFilters string,
+	// +optional
+	coverageVerbosity string,
+	// +default=true
+	failOnTestError bool,
+	// +optional
+	junit bool,
+	// +optional
+	junitTransform *dagger.File,
+	// +optional
+	minCoverage float64,
+	// +optional
+	retryFailed int,
+	// +optional
+	saxonImage string,
+	// +optional
+	testAssembly string,
+	// +optional
+	testAssemblyNames []string,
+	// +optional
+	testCategory string,
+) (*dagger.Directory, error) {
+	err := d.configureTest(gameSrc, testOptions{
+		Cobertura:                 cobertura,
+		Coverage:                  coverage,
+		CoverageAdditionalMetrics: coverageAdditionalMetrics,
+		CoverageAssemblyFilters:   coverageAssemblyFilters,
+		CoverageBadgeReport:       coverageBadgeReport,
+		CoverageHistory:           coverageHistory,
+		CoverageHtmlReport:        coverageHtmlReport,
+		CoverageHtmlReportHistory: coverageHtmlReportHistory,
+		CoveragePathFilters:       coveragePathFilters,
+		CoverageVerbosity:         coverageVerbosity,
+		Junit:                     junit,
+		JunitTransform:            junitTransform,
+		MinCoverage:               minCoverage,
+		RetryFailed:               retryFailed,
+		SaxonImage:                saxonImage,
+		TestAssembly:              testAssembly,
+		TestAssemblyNames:         testAssemblyNames,
+		TestCategory:              testCategory,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := d.createTestContainer(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		d.releaseLicense(ctx, c)
+	}()
+
+	var errs []error
+
+	resultsName := d.ResultsName
+
+	for _, platform := range []string{"editmode", "playmode"} {
+		d.TestingingPlatform = platform
+
+		// Both platforms would otherwise write the same file
+		if resultsName != "" {
+			d.ResultsName = platform + "-" + resultsName
+		}
+
+		logPath := d.logPath("/results/unity.log", platform+"-")
+
+		tested, err := d.runTests(ctx, c, logPath)
+
+		// The results and log of a failing platform are kept for debugging,
+		// only an editor that never ran leaves nothing to keep
+		if tested != nil {
+			c = d.withTestLog(tested, logPath, platform+"-unity.log")
+		}
+
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", platform, err))
+		}
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		if failOnTestError {
+			return nil, err
+		}
+
+		fmt.Printf("Tests failed, returning the results: %v\n", err)
+	}
+
+	return d.withOutputName(d.getTestResults(c)), nil
+}
+
+// Run the EditMode and PlayMode tests at the same time, in two containers
+//
+// Each container activates its own license, so this needs floating or
+// license server licensing with a free seat per platform. Results for both
+// platforms are merged into one directory, as with test-all.
+func (d *Dirk) TestParallel(
+	ctx context.Context,
+	gameSrc *dagger.Directory,
+	// +optional
+	cobertura bool,
+	// +default=true
+	coverage bool,
+	// +default=true
+	// +optional
+	coverageAdditionalMetrics bool,
+	// +optional
+	coverageAssemblyFilters string,
+	// +default=true
+	// +optional
+	coverageBadgeReport bool,
+	// +optional
+	coverageHistory *dagger.Directory,
+	// +default=true
+	// +optional
+	coverageHtmlReport bool,
+	// +default=true
+	// +optional
+	coverageHtmlReportHistory bool,
+	// +optional
+	coveragePathFilters string,
+	// +optional
+	coverageVerbosity string,
+	// +optional
+	junit bool,
+	// +optional
+	junitTransform *dagger.File,
+	// +optional
+	minCoverage float64,
+	// +optional
+	retryFailed int,
+	// +optional
+	saxonImage string,
+	// +optional
+	testAssembly string,
+	// +optional
+	testAssemblyNames []string,
+	// +optional
+	testCategory string,
+) (*dagger.Directory, error) {
+	err := d.configureTest(gameSrc, testOptions{
+		Cobertura:                 cobertura,
+		Coverage:                  coverage,
+		CoverageAdditionalMetrics: coverageAdditionalMetrics,
+		CoverageAssemblyFilters:   coverageAssemblyFilters,
+		CoverageBadgeReport:       coverageBadgeReport,
+		CoverageHistory:           coverageHistory,
+		CoverageHtmlReport:        coverageHtmlReport,
+		CoverageHtmlReportHistory: coverageHtmlReportHistory,
+		CoveragePathFilters:       coveragePathFilters,
+		CoverageVerbosity:         coverageVerbosity,
+		Junit:                     junit,
+		JunitTransform:            junitTransform,
+		MinCoverage:               minCoverage,
+		RetryFailed:               retryFailed,
+		SaxonImage:                saxonImage,
+		TestAssembly:              testAssembly,
+		TestAssemblyNames:         testAssemblyNames,
+		TestCategory:              testCategory,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	platforms := []string{"editmode", "playmode"}
+	results := make([]*dagger.Directory, len(platforms))
+	errs := make([]error, len(platforms))
+
+	var wg sync.WaitGroup
+
+	for i, platform := range platforms {
+		// Every run keeps its own settings, license lease and Library cache,
+		// as two editors can't share a Library
+		pd := *d
+		pd.TestingingPlatform = platform
+		pd.CacheKey = d.libraryCacheKey() + "-" + platform
+
+		if d.ResultsName != "" {
+			pd.ResultsName = platform + "-" + d.ResultsName
+		}
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			results[i], errs[i] = pd.testPlatform(ctx)
+
+			if errs[i] != nil {
+				errs[i] = fmt.Errorf("%s: %w", platform, errs[i])
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	merged := dag.Directory()
+
+	for _, r := range results {
+		merged = merged.WithDirectory(".", r)
+	}
+
+	return d.withOutputName(merged), nil
+}
+
+// testPlatform runs the tests of the current testing platform in a container
+// of its own and returns the results
+func (d *Dirk) testPlatform(ctx context.Context) (*dagger.Directory, error) {
+	c, err := d.createTestContainer(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		d.releaseLicense(ctx, c)
+	}()
+
+	logPath := d.logPath("/results/unity.log", d.TestingingPlatform+"-")
+
+	tested, err := d.runTests(ctx, c, logPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return d.getTestResults(d.withTestLog(tested, logPath, d.TestingingPlatform+"-unity.log")), nil
+}
+
+// Verify runs the tests and, only once they pass, builds the project
+//
+// Both phases share one licensed container and Library cache. The returned
+// directory holds the test results under results and the build under builds.
+// When the tests fail the build is skipped and Verify fails with the test
+// failure, or with failOnTestError off returns the test results alone and
+// logs the failure.
+func (d *Dirk) Verify(
+	ctx context.Context,
+	gameSrc *dagger.Directory,
+	// +optional
+	androidAppBundle bool,
+	// +optional
+	architecture string,
+	// +optional
+	bootScene string,
+	// +optional
+	buildAddressables bool,
+	// +optional
+	buildMethod string,
+	// +optional
+	buildMetrics bool,
+	// +optional
+	buildName string,
+	// +optional
+	buildNumber int,
+	// +optional
+	buildTarget string,
+	// +optional
+	bundleVersion string,
+	// +optional
+	cobertura bool,
+	// +default=true
+	coverage bool,
+	// +default=true
+	// +optional
+	coverageAdditionalMetrics bool,
+	// +optional
+	coverageAssemblyFilters string,
+	// +default=true
+	// +optional
+	coverageBadgeReport bool,
+	// +optional
+	coverageHistory *dagger.Directory,
+	// +optional
+	coverageHistoryPath string,
+	// +default=true
+	// +optional
+	coverageHtmlReport bool,
+	// +default=true
+	// +optional
+	coverageHtmlReportHistory bool,
+	// +optional
+	coveragePathFilters string,
+	// +optional
+	coverageResultsPath string,
+	// +optional
+	coverageVerbosity string,
+	// +optional
+	development bool,
+	// +default=true
+	failOnTestError bool,
+	// +optional
+	il2cppArgs []string,
+	// +optional
+	junit bool,
+	// +optional
+	junitTransform *dagger.File,
+	// +optional
+	keystore *dagger.File,
+	// +optional
+	keystoreAlias string,
+	// +optional
+	keystoreAliasPass *dagger.Secret,
+	// +optional
+	keystorePass *dagger.Secret,
+	// +optional
+	maxBuildSize int,
+	// +optional
+	minCoverage float64,
+	// +optional
+	outputLayout string,
+	// +optional
+	playerSettings *dagger.File,
+	// +optional
+	postBuildScript *dagger.File,
+	// +optional
+	resultsName string,
+	// +optional
+	retryFailed int,
+	// +optional
+	saxonImage string,
+	// +optional
+	scenes []string,
+	// +optional
+	scriptingBackend string,
+	// +optional
+	serverBuild bool,
+	// +optional
+	signingCert *dagger.File,
+	// +optional
+	signingCertPass *dagger.Secret,
+	// +optional
+	signingIdentity string,
+	// +optional
+	testAssembly string,
+	// +optional
+	testAssemblyNames []string,
+	// +optional
+	testCategory string,
+	// +optional
+	testingingPlatform string,
+	// +optional
+	textureCompression string,
+	// +optional
+	warningsAsErrors bool,
+	// +optional
+	webglCompression string,
+) (*dagger.Directory, error) {
+	err := d.configureVerify(gameSrc, buildOptions{
+		AndroidAppBundle:   androidAppBundle,
+		Architecture:       architecture,
+		BootScene:          bootScene,
+		BuildAddressables:  buildAddressables,
+		BuildMethod:        buildMethod,
+		BuildMetrics:       buildMetrics,
+		BuildName:          buildName,
+		BuildNumber:        buildNumber,
+		BuildTarget:        buildTarget,
+		BundleVersion:      bundleVersion,
+		Development:        development,
+		Il2cppArgs:         il2cppArgs,
+		Keystore:           keystore,
+		KeystoreAlias:      keystoreAlias,
+		KeystoreAliasPass:  keystoreAliasPass,
+		KeystorePass:       keystorePass,
+		MaxBuildSize:       maxBuildSize,
+		OutputLayout:       outputLayout,
+		PlayerSettings:     playerSettings,
+		PostBuildScript:    postBuildScript,
+		Scenes:             scenes,
+		ScriptingBackend:   scriptingBackend,
+		ServerBuild:        serverBuild,
+		SigningCert:        signingCert,
+		SigningCertPass:    signingCertPass,
+		SigningIdentity:    signingIdentity,
+		TextureCompression: textureCompression,
+		WarningsAsErrors:   warningsAsErrors,
+		WebglCompression:   webglCompression,
+	}, testOptions{
+		Cobertura:                 cobertura,
+		Coverage:                  coverage,
+		CoverageAdditionalMetrics: coverageAdditionalMetrics,
+		CoverageAssemblyFilters:   coverageAssemblyFilters,
+		CoverageBadgeReport:       coverageBadgeReport,
+		CoverageHistory:           coverageHistory,
+		CoverageHistoryPath:       coverageHistoryPath,
+		CoverageHtmlReport:        coverageHtmlReport,
+		CoverageHtmlReportHistory: coverageHtmlReportHistory,
+		CoveragePathFilters:       coveragePathFilters,
+		CoverageResultsPath:       coverageResultsPath,
+		CoverageVerbosity:         coverageVerbosity,
+		Junit:                     junit,
+		JunitTransform:            junitTransform,
+		MinCoverage:               minCoverage,
+		ResultsName:               resultsName,
+		RetryFailed:               retryFailed,
+		SaxonImage:                saxonImage,
+		TestAssembly:              testAssembly,
+		TestAssemblyNames:         testAssemblyNames,
+		TestCategory:              testCategory,
+		TestingingPlatform:        testingingPlatform,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := d.checkMacSigning(ctx, []string{d.BuildTarget}); err != nil {
+		return nil, err
+	}
+
+	c, err := d.createBuildContainer(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		d.releaseLicense(ctx, c)
+	}()
+
+	testLogPath := d.logPath("/results/unity.log", "")
+
+	if d.LogPath != "" {
+		// The build log would otherwise overwrite it
+		testLogPath = d.logPath("/results/unity.log", "test-")
+	}
+
+	tested, err := d.runTests(ctx, c, testLogPath)
+
+	if tested != nil {
+		c = d.withTestLog(tested, testLogPath, "unity.log")
+	}
+
+	if err != nil {
+		if failOnTestError || tested == nil {
+			return nil, fmt.Errorf("tests failed, skipping the build: %w", err)
+		}
+
+		fmt.Printf("Tests failed, skipping the build and returning the results: %v\n", err)
+
+		return d.withOutputName(dag.Directory().WithDirectory("results", d.getTestResults(c))), nil
+	}
+
+	buildPath := d.buildPath("/builds/")
+
+	built, err := d.build(ctx, c, buildPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c = built
+
+	finished, err := d.finishBuild(ctx, c, buildPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c = finished
+
+	verified := dag.Directory().
+		WithDirectory("results", d.getTestResults(c)).
+		WithDirectory("builds", d.getBuildArtifact(c))
+
+	return d.withOutputName(verified), nil
+}
+
+// Activate the license without building and return the licensing log
+//
+// Useful to validate credentials quickly. The license is returned afterwards.
+func (d *Dirk) Activate(
+	ctx context.Context,
+	gameSrc *dagger.Directory,
+) (string, error) {
+	err := d.configureLicense(gameSrc)
+
+	if err != nil {
+		return "", err
+	}
+
+	c, err := d.createBaseImage()
+
+	if err != nil {
+		return "", err
+	}
+
+	c, err = d.withSecrets(ctx, c, "unity_secrets.env")
+
+	if err != nil {
+		return "", err
+	}
+
+	c, err = d.register(ctx, c)
+
+	if err != nil {
+		return "", err
+	}
+
+	defer func() {
+		d.releaseLicense(ctx, c)
+	}()
+
+	checked, err := d.runStep(ctx, c.WithExec(append(d.baseCommand(), "-quit", "-logFile", "/dev/stdout"),
+		dagger.ContainerWithExecOpts{
+			Expect: dagger.ReturnTypeAny,
+		},
+	), "license check")
+
+	if err != nil {
+		return "", err
+	}
+
+	c = checked
+
+	log, err := c.File(licensingLogPath).Contents(ctx)
+
+	if err != nil {
+		log, _ = c.Stdout(ctx)
+	}
+
+	exitCode, err := c.ExitCode(ctx)
+
+	if err != nil {
+		return "", err
+	}
+
+	noLicense := slices.ContainsFunc(missingLicenseMarkers, func(marker string) bool {
+		return strings.Contains(log, marker)
+	})
+
+	if exitCode != 0 || noLicense {
+		return "", fmt.Errorf("license check failed with exit code %d:\n%s", exitCode, log)
+	}
+
+	return log, nil
+}
+
+// Activate the license and report which license is active
+//
+// The type, serial and expiry are reported by the editor after activating,
+// e.g. to catch a personal license activated from a stale ULF. For floating
+// licenses the lease token is reported instead of the serial.
+func (d *Dirk) LicenseStatus(
+	ctx context.Context,
+	gameSrc *dagger.Directory,
+) (*LicenseStatus, error) {
+	err := d.configureLicense(gameSrc)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := d.createBaseImage()
+
+	if err != nil {
+		return nil, err
+	}
+
+	c, err = d.withSecrets(ctx, c, "unity_secrets.env")
+
+	if err != nil {
+		return nil, err
+	}
+
+	c, err = d.register(ctx, c)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		d.releaseLicense(ctx, c)
+	}()
+
+	return d.licenseStatus(ctx, c)
+}
+
+// configureTest resolves the test settings from the environment, the
+// unity_test.env dotenv and the given arguments, in that order
+func (d *Dirk) configureTest(gameSrc *dagger.Directory, o testOptions) error {
+	if err := d.configureEditor(gameSrc, "./unity_test.env"); err != nil {
+		return err
+	}
+
+	if err := d.configureTestSettings(gameSrc, o); err != nil {
+		return err
+	}
+
+	if o.NoLicense {
+		return nil
+	}
+
+	return d.checkLicensing()
+}
+
+// configureTestSettings resolves the settings only tests use, on top of the
+// shared editor settings
+func (d *Dirk) configureTestSettings(gameSrc *dagger.Directory, o testOptions) error {
+	d.Cobertura, _ = strconv.ParseBool(os.Getenv("DIRK_COBERTURA"))
+	d.Coverage = true
+
+	if _, b := os.LookupEnv("DIRK_COVERAGE"); b {
+		d.Coverage, _ = strconv.ParseBool(os.Getenv("DIRK_COVERAGE"))
+	}
+
+	d.CoverageAdditionalMetrics = true
+
+	if _, b := os.LookupEnv("DIRK_COVERAGE_ADDITIONAL_METRICS"); b {
+		d.CoverageAdditionalMetrics, _ = strconv.ParseBool(os.Getenv("DIRK_COVERAGE_ADDITIONAL_METRICS"))
+	}
+
+	d.CoverageAssemblyFilters = os.Getenv("DIRK_COVERAGE_ASSEMBLY_FILTERS")
+	d.CoverageBadgeReport = true
+
+	if _, b := os.LookupEnv("DIRK_COVERAGE_BADGE_REPORT"); b {
+		d.CoverageBadgeReport, _ = strconv.ParseBool(os.Getenv("DIRK_COVERAGE_BADGE_REPORT"))
+	}
+
+	if _, b := os.LookupEnv("DIRK_COVERAGE_HISTORY"); b {
+		d.CoverageHistory = gameSrc.Directory(os.Getenv("DIRK_COVERAGE_HISTORY"))
+	}
+
+	d.CoverageHistoryPath = os.Getenv("DIRK_COVERAGE_HISTORY_PATH")
+	d.CoverageHtmlReport = true
+
+	if _, b := os.LookupEnv("DIRK_COVERAGE_HTML_REPORT"); b {
+		d.CoverageHtmlReport, _ = strconv.ParseBool(os.Getenv("DIRK_COVERAGE_HTML_REPORT"))
+	}
+
+	d.CoverageHtmlReportHistory = true
+
+	if _, b := os.LookupEnv("DIRK_COVERAGE_HTML_REPORT_HISTORY"); b {
+		d.CoverageHtmlReportHistory, _ = strconv.ParseBool(os.Getenv("DIRK_COVERAGE_HTML_REPORT_HISTORY"))
+	}
+
+	d.CoveragePathFilters = os.Getenv("DIRK_COVERAGE_PATH_FILTERS")
+	d.CoverageResultsPath = os.Getenv("DIRK_COVERAGE_RESULTS_PATH")
+	d.CoverageVerbosity = os.Getenv("DIRK_COVERAGE_VERBOSITY")
+	d.IncludeProject, _ = strconv.ParseBool(os.Getenv("DIRK_INCLUDE_PROJECT"))
+	d.Junit, _ = strconv.ParseBool(os.Getenv("DIRK_JUNIT"))
+
+	if _, b := os.LookupEnv("DIRK_JUNIT_TRANSFORM"); b {
+		d.JunitTransform = gameSrc.File(os.Getenv("DIRK_JUNIT_TRANSFORM"))
+	}
+
+	if _, b := os.LookupEnv("DIRK_MIN_COVERAGE"); b {
+		m, err := strconv.ParseFloat(os.Getenv("DIRK_MIN_COVERAGE"), 64)
+
+		if err != nil {
+			return fmt.Errorf("invalid DIRK_MIN_COVERAGE: %w", err)
+		}
+
+		d.MinCoverage = m
+	}
+
+	d.ResultsName = os.Getenv("DIRK_RESULTS_NAME")
+
+	if err := lookupEnvInt("DIRK_RETRY_FAILED", &d.RetryFailed); err != nil {
+		return err
+	}
+
+	d.SaxonImage = os.Getenv("DIRK_SAXON_IMAGE")
+	d.TestAssembly = os.Getenv("DIRK_TEST_ASSEMBLY")
+
+	if _, b := os.LookupEnv("DIRK_TEST_ASSEMBLY_NAMES"); b {
+		d.TestAssemblyNames = strings.Split(os.Getenv("DIRK_TEST_ASSEMBLY_NAMES"), ",")
+	}
+
+	d.TestCategory = os.Getenv("DIRK_TEST_CATEGORY")
+	d.TestingingPlatform = os.Getenv("DIRK_TESTING_PLATFORM")
+
+	if o.Cobertura {
+		d.Cobertura = o.Cobertura
+	}
+
+	if !o.Coverage {
+		d.Coverage = o.Coverage
+	}
+
+	if !o.CoverageAdditionalMetrics {
+		d.CoverageAdditionalMetrics = o.CoverageAdditionalMetrics
+	}
+
+	if o.CoverageAssemblyFilters != "" {
+		d.CoverageAssemblyFilters = o.CoverageAssemblyFilters
+	}
+
+	if !o.CoverageBadgeReport {
+		d.CoverageBadgeReport = o.CoverageBadgeReport
+	}
+
+	if o.CoverageHistory != nil {
+		d.CoverageHistory = o.CoverageHistory
+	}
+
+	if o.CoverageHistoryPath != "" {
+		d.CoverageHistoryPath = o.CoverageHistoryPath
+	}
+
+	if !o.CoverageHtmlReport {
+		d.CoverageHtmlReport = o.CoverageHtmlReport
+	}
+
+	if !o.CoverageHtmlReportHistory {
+		d.CoverageHtmlReportHistory = o.CoverageHtmlReportHistory
+	}
+
+	if o.CoveragePathFilters != "" {
+		d.CoveragePathFilters = o.CoveragePathFilters
+	}
+
+	if o.CoverageResultsPath != "" {
+		d.CoverageResultsPath = o.CoverageResultsPath
+	}
+
+	if o.CoverageVerbosity != "" {
+		d.CoverageVerbosity = o.CoverageVerbosity
+	}
+
+	if o.IncludeProject {
+		d.IncludeProject = o.IncludeProject
+	}
+
+	if o.Junit {
+		d.Junit = o.Junit
+	}
+
+	if o.JunitTransform != nil {
+		d.JunitTransform = o.JunitTransform
+	}
+
+	if o.MinCoverage != 0 {
+		d.MinCoverage = o.MinCoverage
+	}
+
+	if o.ResultsName != "" {
+		d.ResultsName = o.ResultsName
+	}
+
+	if o.RetryFailed != 0 {
+		d.RetryFailed = o.RetryFailed
+	}
+
+	if o.SaxonImage != "" {
+		d.SaxonImage = o.SaxonImage
+	}
+
+	if o.TestAssembly != "" {
+		d.TestAssembly = o.TestAssembly
+	}
+
+	if len(o.TestAssemblyNames) > 0 {
+		d.TestAssemblyNames = o.TestAssemblyNames
+	}
+
+	if o.TestCategory != "" {
+		d.TestCategory = o.TestCategory
+	}
+
+	if o.TestingingPlatform != "" {
+		d.TestingingPlatform = o.TestingingPlatform
+	}
+
+	for _, name := range d.TestAssemblyNames {
+		if strings.ContainsAny(name, `/\`) {
+			return fmt.Errorf("invalid test assembly name %q: expected an assembly name, not a path", name)
+		}
+	}
+
+	if _, scoped := d.splitDefines(); len(scoped) > 0 {
+		return fmt.Errorf("scoped defines %s are applied by the build method and are not supported for tests", strings.Join(scoped, ", "))
+	}
+
+	if strings.ContainsAny(d.ResultsName, `/\`) || d.ResultsName == "." || d.ResultsName == ".." {
+		return fmt.Errorf("invalid results name %q: expected a file name under /results", d.ResultsName)
+	}
+
+	if d.CoverageHistory != nil && !d.Coverage {
+		return fmt.Errorf("a coverage history requires coverage to be enabled")
+	}
+
+	if err := checkResultsSubpath("coverage results path", d.CoverageResultsPath); err != nil {
+		return err
+	}
+
+	if err := checkResultsSubpath("coverage history path", d.CoverageHistoryPath); err != nil {
+		return err
+	}
+
+	if _, ok := coverageVerbosities[d.CoverageVerbosity]; d.CoverageVerbosity != "" && !ok {
+		return fmt.Errorf("invalid coverage verbosity %q: expected minimal, normal or verbose", d.CoverageVerbosity)
+	}
+
+	if d.Cobertura && !d.Coverage {
+		return fmt.Errorf("a Cobertura report requires coverage to be enabled")
+	}
+
+	if d.MinCoverage > 0 && !d.Coverage {
+		return fmt.Errorf("a minimum coverage requires coverage to be enabled")
+	}
+
+	if d.RetryFailed < 0 {
+		return fmt.Errorf("invalid retry count %d: expected 0 or more", d.RetryFailed)
+	}
+
+	if d.Junit && d.JunitTransform == nil {
+		d.JunitTransform = dag.Directory().
+			WithNewFile("nunit3-junit.xslt", defaultJunitTransform).
+			File("nunit3-junit.xslt")
+	}
+
+	return nil
+}
+
+// configureVerify resolves the test and build settings of verify in one
+// pass. unity.env is applied after unity_test.env, so the build settings win
+// where both set the same variable.
+func (d *Dirk) configureVerify(gameSrc *dagger.Directory, b buildOptions, t testOptions) error {
+	if err := d.configureEditor(gameSrc, "./unity_test.env", "./unity.env"); err != nil {
+		return err
+	}
+
+	if err := d.configureTestSettings(gameSrc, t); err != nil {
+		return err
+	}
+
+	if err := d.configureBuildSettings(gameSrc, b); err != nil {
+		return err
+	}
+
+	return d.checkLicensing()
+}
+
+// createTestContainer creates the licensed editor container with the
+// project source and Library cache mounted
+func (d *Dirk) createTestContainer(ctx context.Context) (*dagger.Container, error) {
+	c, err := d.createBaseImage()
+
+	if err != nil {
+		return nil, err
+	}
+
+	c, err = d.withSecrets(ctx, c, "unity_test_secrets.env")
+
+	if err != nil {
+		return nil, err
+	}
+
+	c = d.withLibrary(c.WithDirectory("/src", d.Src))
+
+	c, err = d.runPreBuildScript(ctx, c)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c, err = d.register(ctx, c)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// runTests runs the tests for the current testing platform, converting the
+// results to JUnit when a transform is configured. Once the editor ran, the
+// container is returned even when the tests fail so the results can be read.
+func (d *Dirk) runTests(ctx context.Context, c *dagger.Container, logPath string) (*dagger.Container, error) {
+	c, err := d.test(ctx, c, logPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	d.Log = c.File(logPath)
+
+	err = d.checkForError(ctx, c, logPath)
+
+	if err != nil {
+		return c, err
+	}
+
+	// Unity doesn't write results when it never got to run the tests, e.g.
+	// on compilation errors, which would otherwise surface as a Saxon error
+	resultsPath := d.resultsPath()
+
+	if _, err := c.File(resultsPath).Sync(ctx); err != nil {
+		return c, fmt.Errorf("tests never ran: %s was not written, run test-log with the same params to see why", resultsPath)
+	}
+
+	if d.JunitTransform != nil {
+		c = d.withJunitResults(c)
+	}
+
+	if d.Cobertura {
+		c = c.WithFile("/results/"+d.TestingingPlatform+"-coverage.cobertura.xml", d.convertCoverageToCobertura(c))
+	}
+
+	err = d.checkTestResults(ctx, c, resultsPath)
+
+	if err != nil && d.RetryFailed > 0 && d.Summary != nil && d.Summary.Failed > 0 {
+		c, err = d.retryFailedTests(ctx, c, resultsPath, logPath)
+	}
+
+	if err != nil {
+		return c, err
+	}
+
+	if d.MinCoverage > 0 {
+		err = d.checkCoverage(ctx, c)
+
+		if err != nil {
+			return c, err
+		}
+	}
+
+	return c, nil
+}
+
+// runPreBuildScript runs the pre-build script from /src, e.g. to generate
+// code, before the license is activated so a failing script never holds a seat
+func (d *Dirk) runPreBuildScript(ctx context.Context, c *dagger.Container) (*dagger.Container, error) {
+	if d.PreBuildScript == nil {
+		return c, nil
+	}
+
+	return d.runScript(ctx, c, d.PreBuildScript, "/src", "pre-build script")
+}
+
+// runPostBuildScript runs the post-build script from the build directory of
+// a successful build, e.g. to sign binaries, with BUILD_PATH and BUILD_LOG set
+func (d *Dirk) runPostBuildScript(ctx context.Context, c *dagger.Container, buildPath string) (*dagger.Container, error) {
+	if d.PostBuildScript == nil {
+		return c, nil
+	}
+
+	c = c.
+		WithEnvVariable("BUILD_PATH", buildPath).
+		WithEnvVariable("BUILD_LOG", d.buildLogPath(buildPath))
+
+	return d.runScript(ctx, c, d.PostBuildScript, buildPath, "post-build script")
+}
+
+// runScript runs script with sh from dir, failing on a non-zero exit code
+func (d *Dirk) runScript(ctx context.Context, c *dagger.Container, script *dagger.File, dir string, step string) (*dagger.Container, error) {
+	fmt.Println("Running " + step)
+
+	scriptPath := "/dirk/" + strings.ReplaceAll(step, " ", "-") + ".sh"
+
+	c, err := d.runStep(ctx, c.
+		WithFile(scriptPath, script).
+		WithExec([]string{"sh", "-c", "cd " + shellQuote([]string{dir}) + " && sh " + shellQuote([]string{scriptPath})},
+			dagger.ContainerWithExecOpts{
+				Expect: dagger.ReturnTypeAny,
+			},
+		), step)
+
+	if err != nil {
+		return nil, err
+	}
+
+	exitCode, err := c.ExitCode(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if exitCode != 0 {
+		stderr, _ := c.Stderr(ctx)
+		return nil, fmt.Errorf("%s exited with code %d: %s", step, exitCode, strings.TrimSpace(stderr))
+	}
+
+	return c, nil
+}
+
+// Editor flag forcing each supported graphics API
+var graphicsApiFlags = map[string]string{
+	"glcore": "-force-glcore",
+	"vulkan": "-force-vulkan",
+	"d3d11":  "-force-d3d11",
+}
+
+// checkGraphicsApi validates the forced graphics API, which only applies when
+// the editor runs with graphics
+func (d *Dirk) checkGraphicsApi() error {
+	if d.GraphicsApi == "" {
+		return nil
+	}
+
+	if _, ok := graphicsApiFlags[d.GraphicsApi]; !ok {
+		return fmt.Errorf("invalid graphics api %q: expected glcore, vulkan or d3d11", d.GraphicsApi)
+	}
+
+	if !d.Graphics {
+		return fmt.Errorf("a graphics api requires graphics to be enabled")
+	}
+
+	return nil
+}
+
+// checkAccelerator validates the Unity Accelerator endpoint is a host:port
+func (d *Dirk) checkAccelerator() error {
+	if d.Accelerator == "" {
+		if d.AcceleratorNamespace != "" {
+			return fmt.Errorf("an accelerator namespace requires an accelerator")
+		}
+
+		return nil
+	}
+
+	host, port, err := net.SplitHostPort(d.Accelerator)
+
+	if err != nil || host == "" {
+		return fmt.Errorf("invalid accelerator %q: expected host:port", d.Accelerator)
+	}
+
+	if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+		return fmt.Errorf("invalid accelerator port %q: expected 1-65535", port)
+	}
+
+	return nil
+}
+
+// checkFast rejects fast mode without the Library cache it relies on and
+// warns that the skipped steps make the result unfit for a release
+func (d *Dirk) checkFast() error {
+	if !d.Fast {
+		return nil
+	}
+
+	if d.NoLibraryCache {
+		return fmt.Errorf("fast mode relies on the Library cache and can't be combined with no library cache")
+	}
+
+	fmt.Println("Fast mode, skipping the assembly updater and reusing the Library cache. Don't use it for release builds")
+
+	return nil
+}
+
+// withLibrary mounts the Library cache at /src/Library. An empty cache is
+// primed from the library seed, while an existing one wins as it is fresher.
+// Without the cache the Library starts from the seed, or empty.
+func (d *Dirk) withLibrary(c *dagger.Container) *dagger.Container {
+	if d.NoLibraryCache {
+		fmt.Println("Library cache disabled, importing the project from scratch")
+
+		if d.LibrarySeed != nil {
+			fmt.Println("Priming the Library from the library seed")
+			return c.WithDirectory("/src/Library", d.LibrarySeed)
+		}
+
+		return c
+	}
+
+	opts := dagger.ContainerWithMountedCacheOpts{}
+
+	if d.LibrarySeed != nil {
+		fmt.Println("Priming an empty Library cache from the library seed")
+		opts.Source = d.LibrarySeed
+	}
+
+	return c.WithMountedCache("/src/Library/", dag.CacheVolume(d.libraryCacheKey()), opts)
+}
+
+// libraryCacheKey names the Library cache volume. Library contents are
+// platform specific, so unless overridden the key includes the platform,
+// build target and Unity version to avoid reimports when switching.
+func (d *Dirk) libraryCacheKey() string {
+	if d.CacheKey != "" {
+		return d.CacheKey
+	}
+
+	key := "lib"
+
+	for _, part := range []string{d.Platform, d.BuildTarget, d.UnityVersion} {
+		if part != "" {
+			key += "-" + part
+		}
+	}
+
+	return key
+}
+
+// packageCacheKey names the UPM global package cache volume. Downloaded
+// packages only depend on the editor version, so it is shared across
+// platforms and, with an explicit key, across projects.
+func (d *Dirk) packageCacheKey() string {
+	if d.PackageCacheKey != "" {
+		return d.PackageCacheKey
+	}
+
+	return "upm-" + d.UnityVersion
+}
+
+// Return the Unity version a project targets, as read from
+// ProjectSettings/ProjectVersion.txt, e.g. to select images downstream
+func (d *Dirk) DetermineUnityVersion(src *dagger.Directory) (string, error) {
+	d.Src = src
+
+	return d.determineUnityProjectVersion()
+}
+
+// resolveUnityVersion detects the editor version from the project unless one
+// was given explicitly, e.g. to try a newer patch release before upgrading
+func (d *Dirk) resolveUnityVersion() error {
+	if d.UnityVersion != "" {
+		fmt.Println("Unity version override in effect: " + d.UnityVersion)
+		return nil
+	}
+
+	v, err := d.determineUnityProjectVersion()
+
+	if err != nil {
+		return err
+	}
+
+	d.UnityVersion = v
+
+	return nil
+}
+
+func (d *Dirk) determineUnityProjectVersion() (string, error) {
+	return readProjectVersion(context.Background(), d.Src.File("ProjectSettings/ProjectVersion.txt"))
+}
+
+// fileContents is the part of *dagger.File readProjectVersion needs, so it
+// can be tested without an engine
+type fileContents interface {
+	Contents(ctx context.Context) (string, error)
+}
+
+// readProjectVersion reads the editor version from ProjectVersion.txt
+func readProjectVersion(ctx context.Context, f fileContents) (string, error) {
+	s, err := f.Contents(ctx)
+
+	if err != nil {
+		return "", fmt.Errorf("could not read ProjectSettings/ProjectVersion.txt: %w", err)
+	}
+
+	return parseProjectVersion(s)
+}
+
+// parseProjectVersion returns the m_EditorVersion of a ProjectVersion.txt
+func parseProjectVersion(s string) (string, error) {
+	for _, line := range strings.Split(s, "\n") {
+		key, value, found := strings.Cut(line, ":")
+
+		if !found || strings.TrimSpace(key) != "m_EditorVersion" {
+			continue
+		}
+
+		// Strip a trailing revision such as "2022.3.10f1 (abc123)"
+		value, _, _ = strings.Cut(value, "(")
+		value = strings.TrimSpace(value)
+
+		if value == "" {
+			return "", fmt.Errorf("m_EditorVersion is empty in ProjectSettings/ProjectVersion.txt")
+		}
+
+		return value, nil
+	}
+
+	return "", fmt.Errorf("m_EditorVersion not found in ProjectSettings/ProjectVersion.txt")
+}
+
+// withPackagesManifest replaces Packages/manifest.json, e.g. to point UPM at
+// an internal registry, without touching the source tree on disk
+func (d *Dirk) withPackagesManifest(src *dagger.Directory) (*dagger.Directory, error) {
+	manifest, err := d.PackagesManifest.Contents(context.Background())
+
+	if err != nil {
+		return nil, fmt.Errorf("could not read packages manifest: %w", err)
+	}
+
+	if !json.Valid([]byte(manifest)) {
+		return nil, fmt.Errorf("packages manifest is not valid JSON")
+	}
+
+	fmt.Println("Using custom packages manifest")
+
+	return src.WithFile("Packages/manifest.json", d.PackagesManifest), nil
+}
+
+// nonEmptyDefines drops the blank entries left by splitting an empty or
+// trailing-comma list, which would otherwise write an empty -define:
+func nonEmptyDefines(defines []string) []string {
+	kept := []string{}
+
+	for _, define := range defines {
+		if define = strings.TrimSpace(define); define != "" {
+			kept = append(kept, define)
+		}
+	}
+
+	return kept
+}
+
+// splitDefines separates the defines scoped to a named build target, such as
+// Server:ENABLE_SERVER, from the unscoped ones
+func (d *Dirk) splitDefines() (unscoped []string, scoped []string) {
+	for _, define := range d.Defines {
+		if strings.Contains(define, ":") {
+			scoped = append(scoped, define)
+		} else {
+			unscoped = append(unscoped, define)
+		}
+	}
+
+	return unscoped, scoped
+}
+
+// checkScopedDefines rejects scoped defines missing a target or a symbol
+func checkScopedDefines(defines []string) error {
+	for _, define := range defines {
+		target, symbol, found := strings.Cut(define, ":")
+
+		if found && (target == "" || symbol == "") {
+			return fmt.Errorf("invalid scoped define %q: expected <NamedBuildTarget>:<SYMBOL>", define)
+		}
+	}
+
+	return nil
+}
+
+// withDefines appends the unscoped scripting define symbols to Assets/csc.rsp
+// so they apply to every compiled assembly, whichever build method or test
+// run follows
+func (d *Dirk) withDefines(src *dagger.Directory) *dagger.Directory {
+	defines, _ := d.splitDefines()
+
+	if len(defines) == 0 {
+		return src
+	}
+
+	fmt.Println("Scripting defines: " + strings.Join(defines, ";"))
+
+	// A missing csc.rsp simply means there is nothing to preserve
+	rsp, _ := src.File("Assets/csc.rsp").Contents(context.Background())
+
+	return src.WithNewFile("Assets/csc.rsp", rsp+"\n-define:"+strings.Join(defines, ";")+"\n")
+}
+
+// buildCommand runs the build method to build into buildPath
+func (d *Dirk) buildCommand(buildPath string) []string {
+	return append(d.baseCommand(),
+		[]string{
+			"-projectPath",
+			"/src",
+			"-buildTarget",
+			d.BuildTarget,
+			"-customBuildPath",
+			buildPath,
+			"-customBuildName",
+			d.BuildName,
+			"-customBuildTarget",
+			d.BuildTarget,
+			"-quit",
+			"-executeMethod",
+			d.BuildMethod,
+		}...,
+	)
+}
+
+func (d *Dirk) build(ctx context.Context, c *dagger.Container, buildPath string) (*dagger.Container, error) {
+	if d.BuildAddressables {
+		built, err := d.buildAddressables(ctx, c, buildPath)
+
+		if err != nil {
+			return nil, err
+		}
+
+		c = built
+	}
+
+	cmd := d.withExtraArgs(d.buildCommand(buildPath))
+	cmd = d.withLogFile(cmd, d.buildLogPath(buildPath))
+
+	if strings.EqualFold(d.BuildTarget, "Android") {
+		c = d.withAndroidKeystore(c)
+
+		if d.AndroidAppBundle {
+			fmt.Println("Building Android App Bundle (AAB)")
+		} else {
+			fmt.Println("Building Android package (APK)")
+		}
+
+		// Read by BuildCommand.HandleAndroidAppBundle
+		c = c.WithEnvVariable("BUILD_APP_BUNDLE", strconv.FormatBool(d.AndroidAppBundle))
+	}
+
+	if d.TextureCompression != "" {
+		if strings.EqualFold(d.BuildTarget, "Android") {
+			fmt.Println("Using texture compression " + d.TextureCompression)
+
+			// Read by BuildCommand.HandleAndroidTextureCompression
+			c = c.WithEnvVariable("TEXTURE_COMPRESSION", textureCompressions[d.TextureCompression])
+		} else {
+			fmt.Println("Ignoring texture compression, it only applies to Android")
+		}
+	}
+
+	if strings.EqualFold(d.BuildTarget, "WebGL") && d.WebglCompression != "" {
+		fmt.Println("Using WebGL compression " + d.WebglCompression)
+
+		// Read by BuildCommand.HandleWebGLCompression
+		c = c.WithEnvVariable("WEBGL_COMPRESSION", d.WebglCompression)
+	}
+
+	if _, scoped := d.splitDefines(); len(scoped) > 0 {
+		fmt.Println("Scoped scripting defines: " + strings.Join(scoped, ";"))
+
+		// Read by BuildCommand.HandleScopedDefines
+		c = c.WithEnvVariable("SCOPED_DEFINES", strings.Join(scoped, ";"))
+	}
+
+	if d.ServerBuild {
+		fmt.Println("Building the Dedicated Server subtarget")
+
+		// Read by BuildCommand.HandleServerBuild
+		c = c.WithEnvVariable("BUILD_SERVER", "true")
+	}
+
+	if d.PlayerSettings != nil {
+		fmt.Println("Applying player settings overrides")
+
+		// Read by BuildCommand.HandlePlayerSettings
+		c = c.
+			WithFile("/player-settings.json", d.PlayerSettings).
+			WithEnvVariable("PLAYER_SETTINGS", "/player-settings.json")
+	}
+
+	if d.Architecture != "" {
+		fmt.Println("Building for architecture " + d.Architecture)
+
+		// Read by BuildCommand.HandleArchitecture
+		c = c.WithEnvVariable("ARCHITECTURE", architectures[d.Architecture])
+	}
+
+	if d.BootScene != "" {
+		fmt.Println("Booting into scene " + d.BootScene)
+
+		// Read by BuildCommand.GetEnabledScenes
+		c = c.WithEnvVariable("BOOT_SCENE", d.BootScene)
+	}
+
+	if len(d.Scenes) > 0 {
+		fmt.Println("Building scenes " + strings.Join(d.Scenes, ", "))
+
+		// Read by BuildCommand.GetEnabledScenes
+		c = c.WithEnvVariable("BUILD_SCENES", strings.Join(d.Scenes, ";"))
+	}
+
+	if d.ScriptingBackend != "" {
+		fmt.Println("Using scripting backend " + d.ScriptingBackend)
+
+		// Read by BuildCommand.SetScriptingBackendFromEnv
+		c = c.WithEnvVariable("SCRIPTING_BACKEND", scriptingBackends[d.ScriptingBackend])
+	}
+
+	if len(d.Il2cppArgs) > 0 {
+		fmt.Println("Using IL2CPP arguments " + strings.Join(d.Il2cppArgs, " "))
+
+		// Read by BuildCommand.HandleIl2cppArgs
+		c = c.WithEnvVariable("IL2CPP_ARGS", strings.Join(d.Il2cppArgs, " "))
+	}
+
+	if d.BundleVersion != "" {
+		fmt.Println("Stamping bundle version " + d.BundleVersion)
+		c = c.WithEnvVariable("VERSION_NUMBER_VAR", d.BundleVersion)
+	}
+
+	if d.BuildNumber > 0 {
+		fmt.Println("Stamping build number " + strconv.Itoa(d.BuildNumber))
+		c = c.WithEnvVariable("VERSION_BUILD_VAR", strconv.Itoa(d.BuildNumber))
+	}
+
+	if d.Development {
+		// Read by BuildCommand.GetBuildOptions
+		c = c.WithEnvVariable("BuildOptions", "Development,AllowDebugging")
+	}
+
+	c, err := d.runStep(ctx, c.
+		WithExec(cmd,
+			dagger.ContainerWithExecOpts{
+				Expect: dagger.ReturnTypeAny,
+			},
+		), "build")
+
+	if err != nil {
+		return nil, err
+	}
+
+	d.Log = c.File(d.buildLogPath(buildPath))
+
+	exitCode, err := c.ExitCode(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	d.BuildExitCode = exitCode
+
+	return c, nil
+}
+
+// finishBuild checks the build the editor wrote to buildPath, then adds the
+// build report, signs it, runs the post-build script and the metrics and
+// copies in the log
+func (d *Dirk) finishBuild(ctx context.Context, c *dagger.Container, buildPath string) (*dagger.Container, error) {
+	if err := d.checkForError(ctx, c, d.buildLogPath(buildPath)); err != nil {
+		return nil, err
+	}
+
+	if d.BuildExitCode != 0 {
+		return nil, fmt.Errorf("unity build exited with code %d", d.BuildExitCode)
+	}
+
+	if err := d.checkWarnings(ctx, c, d.buildLogPath(buildPath)); err != nil {
+		return nil, err
+	}
+
+	c = d.withBuildReport(ctx, c, buildPath)
+
+	c, err := d.signMacBuild(ctx, c, buildPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c, err = d.runPostBuildScript(ctx, c, buildPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	c, err = d.withBuildMetrics(ctx, c, buildPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return d.withBuildLog(c, buildPath), nil
+}
+
+// scriptingBackends maps the accepted backends to Unity's
+// ScriptingImplementation enum names
+var scriptingBackends = map[string]string{
+	"il2cpp": "IL2CPP",
+	"mono2x": "Mono2x",
+}
+
+// il2cppOnlyTargets can't be built with Mono
+var il2cppOnlyTargets = []string{"iOS", "tvOS", "VisionOS", "WebGL"}
+
+// checkScriptingBackend rejects unknown backends and combinations the
+// target doesn't support. An empty backend keeps the project's setting.
+func checkScriptingBackend(target string, backend string) error {
+	if backend == "" {
+		return nil
+	}
+
+	if _, ok := scriptingBackends[backend]; !ok {
+		return fmt.Errorf("invalid scripting backend %q: expected il2cpp or mono2x", backend)
+	}
+
+	for _, t := range il2cppOnlyTargets {
+		if backend != "il2cpp" && strings.EqualFold(target, t) {
+			return fmt.Errorf("scripting backend %s is not supported for %s: %s only supports il2cpp", backend, target, t)
+		}
+	}
+
+	return nil
+}
+
+// textureCompressions maps the accepted Android texture compression formats
+// to Unity's MobileTextureSubtarget enum names
+var textureCompressions = map[string]string{
+	"generic": "Generic",
+	"dxt":     "DXT",
+	"pvrtc":   "PVRTC",
+	"etc":     "ETC",
+	"etc2":    "ETC2",
+	"astc":    "ASTC",
+}
+
+// checkTextureCompression rejects unknown Android texture compression
+// formats. An empty format keeps the project's setting.
+func checkTextureCompression(format string) error {
+	if format == "" {
+		return nil
+	}
+
+	if _, ok := textureCompressions[format]; !ok {
+		return fmt.Errorf("invalid texture compression %q: expected generic, dxt, pvrtc, etc, etc2 or astc", format)
+	}
+
+	return nil
+}
+
+// buildAddressables runs BuildCommand.BuildAddressables in its own editor
+// session, since the player build needs the content and the catalog in place
+func (d *Dirk) buildAddressables(ctx context.Context, c *dagger.Container, buildPath string) (*dagger.Container, error) {
+	fmt.Println("Building Addressables content")
+
+	return d.runEditorMethod(ctx, c, "BuildCommand.BuildAddressables", buildPath, buildPath+"addressables.log", "addressables build")
+}
+
+// runEditorMethod runs a static editor method for the build target in its
+// own editor session, failing on errors in the log or a non-zero exit code
+func (d *Dirk) runEditorMethod(ctx context.Context, c *dagger.Container, method string, buildPath string, logPath string, step string) (*dagger.Container, error) {
+	cmd := append(d.baseCommand(),
+		[]string{
+			"-projectPath",
+			"/src",
+			"-buildTarget",
+			d.BuildTarget,
+			"-customBuildPath",
+			buildPath,
+			"-customBuildTarget",
+			d.BuildTarget,
+			"-quit",
+			"-executeMethod",
+			method,
+		}...,
+	)
+
+	cmd = d.withLogFile(cmd, logPath)
+
+	c, err := d.runStep(ctx, c.WithExec(cmd,
+		dagger.ContainerWithExecOpts{
+			Expect: dagger.ReturnTypeAny,
+		},
+	), step)
+
+	if err != nil {
+		return nil, err
+	}
+
+	d.Log = c.File(logPath)
+
+	if err := d.checkForError(ctx, c, logPath); err != nil {
+		return nil, err
+	}
+
+	exitCode, err := c.ExitCode(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if exitCode != 0 {
+		return nil, fmt.Errorf("%s exited with code %d", step, exitCode)
+	}
+
+	return c, nil
+}
+
+// checkBootScene fails early when the boot scene isn't a scene in the project,
+// rather than once the editor has imported it
+func (d *Dirk) checkBootScene(ctx context.Context) error {
+	if !strings.HasPrefix(d.BootScene, "Assets/") || !strings.HasSuffix(d.BootScene, ".unity") {
+		return fmt.Errorf("invalid boot scene %q: expected an Assets/ path to a .unity scene", d.BootScene)
+	}
+
+	if _, err := d.Src.File(d.BootScene).Sync(ctx); err != nil {
+		return fmt.Errorf("boot scene %s was not found in the project", d.BootScene)
+	}
+
+	return nil
+}
+
+// checkServerBuild rejects Dedicated Server builds for non standalone targets
+// and editors older than 2021.2, which introduced the subtarget
+func (d *Dirk) checkServerBuild(target string) error {
+	if !d.ServerBuild {
+		return nil
+	}
+
+	if target != "" && !strings.HasPrefix(target, "Standalone") {
+		return fmt.Errorf("server builds require a standalone build target, got %s", target)
+	}
+
+	if !unityVersionAtLeast(d.UnityVersion, 2021, 2) {
+		return fmt.Errorf("server builds require Unity 2021.2 or later, got %s", d.UnityVersion)
+	}
+
+	return nil
+}
+
+// architectures maps the accepted standalone architectures to Unity's
+// OSArchitecture enum names
+var architectures = map[string]string{
+	"x64":       "x64",
+	"arm64":     "ARM64",
+	"universal": "x64ARM64",
+}
+
+// checkArchitecture rejects architectures the standalone target or editor
+// can't build. An empty architecture keeps the project's setting, and the
+// targets of a matrix are checked one by one.
+func (d *Dirk) checkArchitecture(target string) error {
+	if d.Architecture == "" || target == "" {
+		return nil
+	}
+
+	if _, ok := architectures[d.Architecture]; !ok {
+		return fmt.Errorf("invalid architecture %q: expected x64, arm64 or universal", d.Architecture)
+	}
+
+	switch target {
+	case "StandaloneOSX":
+		if d.Architecture != "x64" && !unityVersionAtLeast(d.UnityVersion, 2020, 2) {
+			return fmt.Errorf("%s macOS builds require Unity 2020.2 or later, got %s", d.Architecture, d.UnityVersion)
+		}
+	case "StandaloneWindows64":
+		if d.Architecture == "universal" {
+			return fmt.Errorf("universal builds are only supported for StandaloneOSX")
+		}
+
+		if d.Architecture == "arm64" && !unityVersionAtLeast(d.UnityVersion, 2023, 1) {
+			return fmt.Errorf("arm64 Windows builds require Unity 2023.1 or later, got %s", d.UnityVersion)
+		}
+	case "StandaloneLinux64":
+		if d.Architecture == "universal" {
+			return fmt.Errorf("universal builds are only supported for StandaloneOSX")
+		}
+
+		if d.Architecture == "arm64" && !unityVersionAtLeast(d.UnityVersion, 6000, 0) {
+			return fmt.Errorf("arm64 Linux builds require Unity 6000.0 or later, got %s", d.UnityVersion)
+		}
+	default:
+		return fmt.Errorf("architecture %s is not supported for %s: expected StandaloneOSX, StandaloneWindows64 or StandaloneLinux64", d.Architecture, target)
+	}
+
+	return nil
+}
+
+// unityVersionAtLeast compares the major and minor parts of an editor
+// version such as 2022.3.10f1
+func unityVersionAtLeast(version string, major int, minor int) bool {
+	parts := strings.SplitN(version, ".", 3)
+
+	if len(parts) < 2 {
+		return false
+	}
+
+	vMajor, err := strconv.Atoi(parts[0])
+
+	if err != nil {
+		return false
+	}
+
+	vMinor, err := strconv.Atoi(parts[1])
+
+	if err != nil {
+		return false
+	}
+
+	return vMajor > major || (vMajor == major && vMinor >= minor)
+}
+
+// withAndroidKeystore mounts the keystore where BuildCommand.HandleAndroidKeystore
+// expects it and passes the credentials as env vars so they never show up
+// in the editor command line
+func (d *Dirk) withAndroidKeystore(c *dagger.Container) *dagger.Container {
+	if d.Keystore == nil {
+		return c
+	}
+
+	fmt.Println("Signing with custom keystore")
+
+	c = c.WithFile("/src/keystore.keystore", d.Keystore)
+
+	if d.KeystoreAlias != "" {
+		c = c.WithEnvVariable("KEY_ALIAS_NAME", d.KeystoreAlias)
+	}
+
+	if d.KeystorePass != nil {
+		c = c.WithSecretVariable("KEYSTORE_PASS", d.KeystorePass)
+	}
+
+	if d.KeystoreAliasPass != nil {
+		c = c.WithSecretVariable("KEY_ALIAS_PASS", d.KeystoreAliasPass)
+	}
+
+	return c
+}
+
+// testAssemblies joins the test assembly and assembly names into the ;
+// separated list expected by -assemblyNames
+func (d *Dirk) testAssemblies() string {
+	var assemblies []string
+
+	if d.TestAssembly != "" {
+		assemblies = append(assemblies, d.TestAssembly)
+	}
+
+	assemblies = append(assemblies, d.TestAssemblyNames...)
+
+	return strings.Join(assemblies, ";")
+}
+
+// resultsPath is where Unity writes the NUnit results, <platform>-results.xml
+// unless a results name is given
+func (d *Dirk) resultsPath() string {
+	if d.ResultsName != "" {
+		return "/results/" + d.ResultsName
+	}
+
+	return "/results/" + d.TestingingPlatform + "-results.xml"
+}
+
+// junitResultsPath follows the results name, e.g. test-results-junit.xml for
+// test-results.xml
+func (d *Dirk) junitResultsPath() string {
+	if d.ResultsName != "" {
+		return "/results/" + strings.TrimSuffix(d.ResultsName, ".xml") + "-junit.xml"
+	}
+
+	return "/results/" + d.TestingingPlatform + "-junit-results.xml"
+}
+
+func (d *Dirk) test(ctx context.Context, c *dagger.Container, logPath string) (*dagger.Container, error) {
+	if d.Coverage && d.CoverageHistory != nil {
+		c = c.WithDirectory(d.coverageHistoryPath(), d.CoverageHistory)
+	}
+
+	cmd := d.withExtraArgs(d.runTestsCommand())
+	cmd = d.withLogFile(cmd, logPath)
+
+	c = c.
+		WithExec(cmd,
+			dagger.ContainerWithExecOpts{
+				Expect: dagger.ReturnTypeAny,
+			},
+		)
+
+	return d.runStep(ctx, c, "test")
+}
+
+// testCommand runs the tests of the current testing platform, writing the
+// results to resultsPath
+func (d *Dirk) testCommand(resultsPath string) []string {
+	return append(d.baseCommand(),
+		[]string{
+			"-projectPath",
+			"/src",
+			"-runTests",
+			"-testResults",
+			resultsPath,
+			"-debugCodeOptimization",
+			"-testPlatform",
+			d.TestingingPlatform,
+		}...)
+}
+
+// runTestsCommand runs the tests of the current testing platform with the
+// configured coverage and filters
+func (d *Dirk) runTestsCommand() []string {
+	cmd := d.testCommand(d.resultsPath())
+
+	if d.Coverage {
+		cmd = append(cmd,
+			"-enableCodeCoverage",
+			"-coverageResultsPath",
+			d.coverageResultsPath(),
+			"-coverageHistoryPath",
+			d.coverageHistoryPath(),
+			"-coverageOptions",
+			d.coverageOptions(),
+		)
+	}
+
+	if d.TestCategory != "" {
+		cmd = append(cmd, "-testCategory", d.TestCategory)
+	}
+
+	if assemblies := d.testAssemblies(); assemblies != "" {
+		cmd = append(cmd, "-assemblyNames", assemblies)
+	}
+
+	return cmd
+}
+
+// buildPath is where the editor writes the build under root. The nested
+// layout adds <target>/<name>/ for upload tooling expecting that structure,
+// unless root already is the target's directory.
+func (d *Dirk) buildPath(root string) string {
+	if d.OutputLayout != "nested" {
+		return root
+	}
+
+	if path.Base(root) == d.BuildTarget {
+		return root + d.BuildName + "/"
+	}
+
+	return root + d.BuildTarget + "/" + d.BuildName + "/"
+}
+
+// logPath is where the editor writes its log, def unless a log path was
+// given. The prefix tells apart the logs of several runs in one container.
+func (d *Dirk) logPath(def string, prefix string) string {
+	p := def
+
+	if d.LogPath != "" {
+		p = d.LogPath
+	}
+
+	return path.Join(path.Dir(p), prefix+path.Base(p))
+}
+
+// buildLogPath is where the editor writes the log of a build into buildPath.
+// A given log path is prefixed with the build target, so the targets of a
+// matrix don't share one log.
+func (d *Dirk) buildLogPath(buildPath string) string {
+	if d.LogPath == "" || d.BuildTarget == "" {
+		return d.logPath(buildPath+"unity.log", "")
+	}
+
+	return d.logPath(buildPath+"unity.log", d.BuildTarget+"-")
+}
+
+// withBuildLog copies a log written outside buildPath into it as unity.log,
+// so the returned build always holds its log
+func (d *Dirk) withBuildLog(c *dagger.Container, buildPath string) *dagger.Container {
+	logPath := d.buildLogPath(buildPath)
+
+	if strings.HasPrefix(logPath, buildPath) {
+		return c
+	}
+
+	return c.WithFile(buildPath+"unity.log", c.File(logPath))
+}
+
+// withTestLog copies a test log written outside /results into it as name, so
+// the returned results always hold their log
+func (d *Dirk) withTestLog(c *dagger.Container, logPath string, name string) *dagger.Container {
+	if strings.HasPrefix(logPath, "/results/") {
+		return c
+	}
+
+	return c.WithFile("/results/"+name, c.File(logPath))
+}
+
+// checkOutputName rejects output names that aren't a single folder name
+func (d *Dirk) checkOutputName() error {
+	if strings.ContainsAny(d.OutputName, `/\`) || d.OutputName == "." || d.OutputName == ".." {
+		return fmt.Errorf("invalid output name %q: expected a folder name", d.OutputName)
+	}
+
+	return nil
+}
+
+// withOutputName nests dir under the output name, if one was given. The
+// container paths stay the same, only the returned directory changes. Single
+// artifacts, such as a zip, a log or a report, are returned as is.
+func (d *Dirk) withOutputName(dir *dagger.Directory) *dagger.Directory {
+	if d.OutputName == "" {
+		return dir
+	}
+
+	return dag.Directory().WithDirectory(d.OutputName, dir)
+}
+
+func (d *Dirk) getBuildArtifact(c *dagger.Container) *dagger.Directory {
+	return c.
+		Directory("/builds")
+}
+
+// archive zips dir into a file called name, keeping permissions and symlinks
+func (d *Dirk) archive(dir *dagger.Directory, name string) *dagger.File {
+	return dag.Container().From("alpine").
+		WithExec([]string{"apk", "add", "--no-cache", "zip"}).
+		WithDirectory("/archive", dir).
+		WithWorkdir("/archive").
+		WithExec([]string{"zip", "-r", "-y", "/" + name, "."}).
+		File("/" + name)
+}
+
+func (d *Dirk) getTestResults(c *dagger.Container) *dagger.Directory {
+	return c.
+		Directory("/results")
+}
+
+// getLibrary snapshots the Library cache. The cache mount itself can't be
+// read as a directory, so it is copied out first.
+func (d *Dirk) getLibrary(c *dagger.Container) *dagger.Directory {
+	fmt.Println("Exporting the Library")
+
+	return c.
+		WithExec([]string{"cp", "-a", "/src/Library/.", "/library/"}).
+		Directory("/library")
+}
+
+// getProject snapshots the project as the editor left it, without the Library
+// cache
+func (d *Dirk) getProject(c *dagger.Container) *dagger.Directory {
+	return c.
+		Directory("/src").
+		WithoutDirectory("Library")
+}
+
+// checkLicense fails early unless exactly one license was provided, rather
+// than letting the editor run unlicensed or activate several licenses at once
+func (d *Dirk) checkLicense() error {
+	var provided []string
+
+	if d.Ulf != nil {
+		provided = append(provided, "--ulf")
+	}
+
+	if d.UlfDir != nil {
+		provided = append(provided, "--ulf-dir")
+	}
+
+	if d.Serial != nil {
+		provided = append(provided, "--serial")
+	}
+
+	if d.ServiceConfig != nil {
+		provided = append(provided, "--service-config")
+	}
+
+	switch len(provided) {
+	case 0:
+		return fmt.Errorf("no license provided: pass --ulf, --ulf-dir, --serial, or --service-config")
+	case 1:
+		return nil
+	default:
+		return fmt.Errorf("conflicting licenses provided (%s): pick one", strings.Join(provided, ", "))
+	}
+}
+
+func (d *Dirk) register(ctx context.Context, c *dagger.Container) (*dagger.Container, error) {
+	var err error
+
+	switch {
+	case d.Ulf != nil || d.UlfDir != nil:
+		fmt.Println("Registering personal license")
+		c, err = d.registerPersonalLicense(ctx, c)
+	case d.Serial != nil:
+		fmt.Println("Registering serial license")
+		c, err = d.registerSerialLicense(ctx, c)
+	case d.ServiceConfig != nil:
+		fmt.Println("Registering license server")
+		c, err = d.registerLicenseServer(ctx, c)
+	default:
+		return c, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := d.checkActivationOutput(ctx, c); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (d *Dirk) registerPersonalLicense(ctx context.Context, c *dagger.Container) (*dagger.Container, error) {
+	ulf, err := d.personalLicense(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return d.runStep(ctx, d.withCredentials(c).
+		WithFile(licenseFilePath, ulf).
+		WithExec(d.personalLicenseCommand(),
+			dagger.ContainerWithExecOpts{
+				Expect: dagger.ReturnTypeAny,
+			},
+		), "license activation")
+}
+
+// personalLicense returns the ULF to activate. From a ULF directory it picks
+// the file named after the full Unity version, e.g. 2022.3.10f1.ulf, or else
+// after its major version, e.g. 2022.ulf, as activations are version-bound.
+func (d *Dirk) personalLicense(ctx context.Context) (*dagger.File, error) {
+	if d.Ulf != nil {
+		return d.Ulf, nil
+	}
+
+	entries, err := d.UlfDir.Entries(ctx)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not list the ULF directory: %w", err)
+	}
+
+	major, _, _ := strings.Cut(d.UnityVersion, ".")
+
+	for _, name := range []string{d.UnityVersion + ".ulf", major + ".ulf"} {
+		if slices.Contains(entries, name) {
+			fmt.Println("Using personal license " + name)
+			return d.UlfDir.File(name), nil
+		}
+	}
+
+	return nil, fmt.Errorf("no ULF found for Unity %s: expected %s.ulf or %s.ulf in the ULF directory", d.UnityVersion, d.UnityVersion, major)
+}
+
+func (d *Dirk) registerSerialLicense(ctx context.Context, c *dagger.Container) (*dagger.Container, error) {
+	return d.activate(ctx, d.withCredentials(c), d.serialLicenseCommand())
+}
+
+// personalLicenseCommand activates the mounted ULF, logging to stdout so
+// checkActivationOutput sees a failed activation. The credentials are only
+// referenced, to be expanded by the shell from withCredentials.
+func (d *Dirk) personalLicenseCommand() []string {
+	return []string{
+		"sh",
+		"-c",
+		shellQuote(d.baseCommand()) + ` -username "$USER" -password "$PASS" -quit -logFile /dev/stdout`,
+	}
+}
+
+// serialLicenseCommand activates the serial, with the credentials only
+// referenced as for personalLicenseCommand
+func (d *Dirk) serialLicenseCommand() []string {
+	return []string{
+		"sh",
+		"-c",
+		shellQuote(d.baseCommand()) + ` -username "$USER" -password "$PASS" -serial "$SERIAL" -quit -logFile /dev/stdout`,
+	}
+}
+
+// withCredentials passes the account and serial as env vars, secrets where
+// possible, so they are expanded by the shell and never appear in the argv
+// of a logged exec. USER and PASS may also come from unity_secrets.env.
+func (d *Dirk) withCredentials(c *dagger.Container) *dagger.Container {
+	if d.User != "" {
+		c = c.WithEnvVariable("USER", d.User)
+	}
+
+	if d.Pass != nil {
+		c = c.WithSecretVariable("PASS", d.Pass)
+	}
+
+	if d.Serial != nil {
+		c = c.WithSecretVariable("SERIAL", d.Serial)
+	}
+
+	return c
+}
+
+func (d *Dirk) registerLicenseServer(ctx context.Context, c *dagger.Container) (*dagger.Container, error) {
+	c = c.WithFile("/usr/share/unity3d/config/services-config.json", d.ServiceConfig)
+
+	cmd := licensingClient + " --acquire-floating"
+
+	if d.LicensingVerbose {
+		cmd += " --verbosity Verbose"
+	}
+
+	activated, err := d.activate(ctx, c, []string{
+		"sh",
+		"-c",
+		cmd,
+	})
+
+	if err != nil {
+		if activated != nil {
+			d.withLicensingLog(ctx, activated)
+		}
+
+		return nil, err
+	}
+
+	c = activated
+
+	stdout, err := c.Stdout(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	d.FloatingLicense = parseFloatingLicense(stdout)
+
+	return c, nil
+}
+
+func (d *Dirk) returnLicense(c *dagger.Container) *dagger.Container {
+
+	cmd := append(d.baseCommand(), []string{"-returnlicense"}...)
+	return c.
+		WithExec(cmd, dagger.ContainerWithExecOpts{
+			Expect: dagger.ReturnTypeAny,
+		})
+}
+
+// Known markers Unity writes to its log when a batchmode run fails
+var unityFailureMarkers = []string{
+	"Aborting batchmode due to failure",
+	"Addressables build failed",
+	"No valid Unity Editor license found",
+	"Unable to find Unity license",
+	"Build completed with a result of 'Failed'",
+	"BuildFailedException",
+	// Not the bare [Licensing::Module] Error prefix, which licensed runs also
+	// log, e.g. for an access token that failed to update
+	"Failed to activate/update license",
+}
+
+// Markers on the executeMethod lines Unity logs when the method to run is
+// missing, after which it exits without producing anything
+var executeMethodFailureMarkers = []string{
+	"could not be found",
+	"couldn't be called",
+}
+
+// runStep evaluates c, cancelling it once the configured timeout is exceeded
+func (d *Dirk) runStep(ctx context.Context, c *dagger.Container, step string) (*dagger.Container, error) {
+	if d.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(d.Timeout)*time.Minute)
+		defer cancel()
+	}
+
+	c, err := c.Sync(ctx)
+
+	if err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("%s timed out after %d minutes", step, d.Timeout)
+		}
+
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// releaseLicense returns the license held by c and logs the outcome. It is
+// meant to be deferred so seats are released even when a run fails.
+func (d *Dirk) releaseLicense(ctx context.Context, c *dagger.Container) {
+	if c == nil {
+		return
+	}
+
+	if d.ServiceConfig != nil {
+		d.returnFloatingLicense(ctx, c)
+		return
 	}
 
-	if platform != "" {
-		d.Platform = platform
+	if d.KeepLicense {
+		fmt.Println("Keeping the license, it was not returned")
+		return
 	}
 
-	if serial != nil {
-		d.Serial = serial
-	}
+	exitCode, err := d.returnLicense(c).ExitCode(ctx)
 
-	if serviceConfig != nil {
-		d.ServiceConfig = serviceConfig
+	if err != nil {
+		fmt.Printf("Failed to return license: %v\n", err)
+		return
 	}
 
-	if targetOs != "" {
-		d.Os = targetOs
+	if exitCode != 0 {
+		fmt.Printf("Failed to return license, exit code %d\n", exitCode)
+		return
 	}
 
-	if ulf != nil {
-		d.Ulf = ulf
-	}
+	fmt.Println("License returned")
+}
 
-	if unityVersion != "" {
-		d.UnityVersion = unityVersion
-	}
+func (d *Dirk) checkForError(ctx context.Context, c *dagger.Container, logPath string) error {
+	log, err := c.File(logPath).Contents(ctx)
 
-	if user != "" {
-		d.User = user
+	if err != nil {
+		return fmt.Errorf("could not read %s: %w", logPath, err)
 	}
 
-	c := d.createBaseImage()
+	for _, line := range strings.Split(log, "\n") {
+		if strings.Contains(line, "executeMethod") {
+			for _, marker := range executeMethodFailureMarkers {
+				if strings.Contains(line, marker) {
+					return fmt.Errorf("unity could not run the build method, check --build-method names a public static method of a class in an Editor folder: %s", strings.TrimSpace(line))
+				}
+			}
+		}
 
-	s = gameSrc.File("./unity_secrets.env")
-	if s != nil {
-		c, _ = NewEnv().Container(ctx, s, c, true)
+		for _, marker := range unityFailureMarkers {
+			if strings.Contains(line, marker) {
+				return fmt.Errorf("unity failed: %s", strings.TrimSpace(line))
+			}
+		}
 	}
 
-	libCache := dag.CacheVolume("lib")
-
-	c = d.register(c)
+	return nil
+}
 
-	c = c.WithDirectory("/src", d.Src).
-		WithMountedCache("/src/Library/", libCache)
+// C# compiler diagnostics, e.g. "Assets/Foo.cs(3,10): warning CS0168: ..."
+var (
+	compilerWarningPattern = regexp.MustCompile(`\bwarning CS\d+:`)
+	compilerErrorPattern   = regexp.MustCompile(`\berror CS\d+:`)
+)
 
-	c = d.build(c)
-	c = d.returnLicense(c)
+// compilerDiagnostics returns the log lines matching pattern. Unity logs some
+// diagnostics more than once, so each is returned once.
+func compilerDiagnostics(log string, pattern *regexp.Regexp) []string {
+	var lines []string
 
-	err = d.checkForError()
+	for _, line := range strings.Split(log, "\n") {
+		line = strings.TrimSpace(line)
 
-	if err != nil {
-		return nil, err
+		if pattern.MatchString(line) && !slices.Contains(lines, line) {
+			lines = append(lines, line)
+		}
 	}
 
-	return d.getBuildArtifact(c), nil
+	return lines
 }
 
-// Test the things
-func (d *Dirk) Test(
-	ctx context.Context,
-	gameSrc *dagger.Directory,
-	// +optional
-	gameciVersion string,
-	// +optional
-	junitTransform *dagger.File,
-	// +optional
-	targetOs string,
-	// +optional
-	pass *dagger.Secret,
-	// +optional
-	platform string,
-	// +optional
-	serial *dagger.Secret,
-	// +optional
-	serviceConfig *dagger.File,
-	// +optional
-	testingingPlatform string,
-	// +optional
-	ulf *dagger.File,
-	// +optional
-	unityVersion string,
-	// +optional
-	user string,
-) (*dagger.Directory, error) {
-	gameSrc = gameSrc.WithoutDirectory(".git")
-	gameSrc = gameSrc.WithoutDirectory(".dagger")
-	gameSrc = gameSrc.WithoutDirectory(".vscode")
-	gameSrc = gameSrc.WithoutFiles([]string{".gitignore", ".gitmodules", ".DS_Store", "dagger.json", "go.work", "LICENSE", "README.md"})
+// compilerErrors returns the script compilation errors in log
+func compilerErrors(log string) []string {
+	return compilerDiagnostics(log, compilerErrorPattern)
+}
 
-	d.Src = gameSrc
+// checkWarnings fails on script compilation warnings when they are treated as
+// errors
+func (d *Dirk) checkWarnings(ctx context.Context, c *dagger.Container, logPath string) error {
+	if !d.WarningsAsErrors {
+		return nil
+	}
 
-	var err error
-	d.UnityVersion, err = d.determineUnityProjectVersion()
+	log, err := c.File(logPath).Contents(ctx)
 
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("could not read %s: %w", logPath, err)
 	}
-	var f, s *dagger.File
 
-	f = gameSrc.File("./unity_test.env")
+	warnings := compilerDiagnostics(log, compilerWarningPattern)
 
-	if f != nil {
-		fmt.Println("Setting env vars")
-		NewEnv().Host(context.Background(), f)
+	if len(warnings) > 0 {
+		return fmt.Errorf("%d script compilation warning(s) treated as errors:\n%s", len(warnings), strings.Join(warnings, "\n"))
 	}
 
-	d.GameciVersion = os.Getenv("DIRK_GAMECI_VERSION")
+	return nil
+}
 
-	if _, b := os.LookupEnv("DIRK_JUNIT_TRANSFORM"); b {
-		d.JunitTransform = gameSrc.File(os.Getenv("DIRK_JUNIT_TRANSFORM"))
-	}
+// Default xvfb screen
+const (
+	defaultScreenWidth  = 640
+	defaultScreenHeight = 480
+	defaultScreenDepth  = 24
+)
 
-	d.Os = os.Getenv("DIRK_OS")
+// Screens given as WIDTHxHEIGHTxDEPTH, e.g. 1920x1080x24
+var screenPattern = regexp.MustCompile(`^[1-9]\d*x[1-9]\d*x[1-9]\d*$`)
 
-	if _, b := os.LookupEnv("DIRK_PASS"); b {
-		d.Pass = dag.Secret(os.Getenv("DIRK_PASS"))
+// checkScreens validates the xvfb screens, which replace the screen size
+// params rather than combine with them
+func (d *Dirk) checkScreens() error {
+	if len(d.Screens) == 0 {
+		return nil
 	}
 
-	d.Platform = os.Getenv("DIRK_PLATFORM")
-
-	if _, b := os.LookupEnv("DIRK_SERIAL"); b {
-		d.Serial = dag.Secret(os.Getenv("DIRK_SERIAL"))
+	if d.ScreenWidth != 0 || d.ScreenHeight != 0 || d.ScreenDepth != 0 {
+		return fmt.Errorf("screens can't be combined with a screen width, height or depth")
 	}
 
-	if _, b := os.LookupEnv("DIRK_SERVICE_CONFIG"); b {
-		d.ServiceConfig = gameSrc.File(os.Getenv("DIRK_SERVICE_CONFIG"))
+	for _, screen := range d.Screens {
+		if !screenPattern.MatchString(screen) {
+			return fmt.Errorf("invalid screen %q: expected WIDTHxHEIGHTxDEPTH, e.g. 1920x1080x24", screen)
+		}
 	}
 
-	d.TestingingPlatform = os.Getenv("DIRK_TESTING_PLATFORM")
+	return nil
+}
 
-	if _, b := os.LookupEnv("DIRK_ULF"); b {
-		d.Ulf = gameSrc.File(os.Getenv("DIRK_ULF"))
-	}
+// screenArgs returns the xvfb -screen args, numbering the screens in order
+func (d *Dirk) screenArgs() []string {
+	if len(d.Screens) > 0 {
+		var args []string
 
-	if _, b := os.LookupEnv("DIRK_UNITY_VERSION"); b {
-		d.UnityVersion = os.Getenv("DIRK_UNITY_VERSION")
+		for i, screen := range d.Screens {
+			args = append(args, fmt.Sprintf("-screen %d %s", i, screen))
+		}
+
+		return args
 	}
 
-	d.User = os.Getenv("DIRK_USER")
+	width, height, depth := d.ScreenWidth, d.ScreenHeight, d.ScreenDepth
 
-	if gameciVersion != "" {
-		d.GameciVersion = gameciVersion
+	if width == 0 {
+		width = defaultScreenWidth
 	}
 
-	if junitTransform != nil {
-		d.JunitTransform = junitTransform
+	if height == 0 {
+		height = defaultScreenHeight
 	}
 
-	if targetOs != "" {
-		d.Os = targetOs
+	if depth == 0 {
+		depth = defaultScreenDepth
 	}
 
-	if pass != nil {
-		d.Pass = pass
-	}
+	return []string{fmt.Sprintf("-screen 0 %dx%dx%d", width, height, depth)}
+}
 
-	if platform != "" {
-		d.Platform = platform
+func (d *Dirk) baseCommand() []string {
+	cmd := []string{
+		"xvfb-run",
+		"--auto-servernum",
+		"--server-args='" + strings.Join(d.screenArgs(), " ") + "'",
+		"unity-editor",
 	}
 
-	if serial != nil {
-		d.Serial = serial
+	if !d.Graphics {
+		cmd = append(cmd, "-nographics")
 	}
 
-	if serviceConfig != nil {
-		d.ServiceConfig = serviceConfig
+	if d.GraphicsApi != "" {
+		cmd = append(cmd, graphicsApiFlags[d.GraphicsApi])
 	}
 
-	if gameSrc != nil {
-		d.Src = gameSrc
+	// Scripts are not scanned for obsolete APIs to upgrade, which only
+	// matters after a Unity version change
+	if d.Fast {
+		cmd = append(cmd, "-disableAssemblyUpdater")
 	}
 
-	if testingingPlatform != "" {
-		d.TestingingPlatform = testingingPlatform
-	}
+	if d.Accelerator != "" {
+		cmd = append(cmd, "-EnableCacheServer", "-cacheServerEndpoint", d.Accelerator)
 
-	if ulf != nil {
-		d.Ulf = ulf
+		if d.AcceleratorNamespace != "" {
+			cmd = append(cmd, "-cacheServerNamespacePrefix", d.AcceleratorNamespace)
+		}
 	}
 
-	if unityVersion != "" {
-		d.UnityVersion = unityVersion
-	}
+	return cmd
+}
 
-	if user != "" {
-		d.User = user
-	}
+// withExtraArgs appends the raw editor arguments and logs the final command
+func (d *Dirk) withExtraArgs(cmd []string) []string {
+	cmd = append(cmd, d.ExtraArgs...)
 
-	c := d.createBaseImage()
+	fmt.Println("Running " + strings.Join(redactCommand(cmd), " "))
+
+	return cmd
+}
 
-	s = gameSrc.File("./unity_test_secrets.env")
+// describeCommand renders cmd as a shell command with credentials redacted,
+// followed by the scripting defines written to Assets/csc.rsp
+func (d *Dirk) describeCommand(cmd []string, logPath string) string {
+	description := shellQuote(d.withLogFile(redactCommand(cmd), logPath)) + "\n"
 
-	if s != nil {
-		c, _ = NewEnv().Container(ctx, s, c, true)
+	if defines, _ := d.splitDefines(); len(defines) > 0 {
+		description += "Scripting defines in Assets/csc.rsp: " + strings.Join(defines, ";") + "\n"
 	}
 
-	libCache := dag.CacheVolume("lib")
+	return description
+}
 
-	c = d.register(c)
+// Editor flags whose value must never be logged
+var secretFlags = []string{"-password", "-serial", "-username"}
 
-	c = c.WithDirectory("/src", d.Src).
-		WithMountedCache("/src/Library/", libCache)
+// redactCommand masks the values of secretFlags, e.g. when extra arguments
+// carry credentials
+func redactCommand(cmd []string) []string {
+	redacted := make([]string, len(cmd))
+	copy(redacted, cmd)
 
-	c = d.test(c)
+	for i := 1; i < len(redacted); i++ {
+		if slices.Contains(secretFlags, strings.ToLower(redacted[i-1])) {
+			redacted[i] = "***"
+		}
+	}
 
-	if junitTransform != nil {
-		f := c.File("/results/" + d.TestingingPlatform + "-results.xml")
-		jf := d.convertTestsToJUNIT(f, junitTransform)
+	return redacted
+}
 
-		c.WithFile("/nunit-transforms/nunit3-junit.xslt", junitTransform)
-		c = c.WithFile("/results/"+d.TestingingPlatform+"-junit-results.xml", jf)
+// withLogFile makes the editor cmd write its log to logPath. In verbose mode
+// the log is also streamed to stdout as it is written.
+func (d *Dirk) withLogFile(cmd []string, logPath string) []string {
+	if !d.Verbose {
+		return append(cmd, "-logFile", logPath)
 	}
 
-	c = d.returnLicense(c)
-
-	err = d.checkForError()
+	cmd = append(cmd, "-logFile", "-")
 
-	if err != nil {
-		return nil, err
+	return []string{
+		"bash",
+		"-c",
+		"set -o pipefail; mkdir -p " + shellQuote([]string{path.Dir(logPath)}) + "; " + shellQuote(cmd) + " | tee " + shellQuote([]string{logPath}),
 	}
-
-	return d.getTestResults(c), nil
 }
 
-func (d *Dirk) determineUnityProjectVersion() (string, error) {
-	ctx := context.Background()
-	s, err := d.Src.File("ProjectSettings/ProjectVersion.txt").Contents(ctx)
+// shellQuote joins args into a command line for sh -c, quoting each one
+func shellQuote(args []string) string {
+	quoted := make([]string, len(args))
 
-	if err != nil {
-		return "", err
+	for i, arg := range args {
+		quoted[i] = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
 	}
 
-	v := strings.Split(strings.Split(s, "\n")[0], ": ")[1]
-
-	return v, nil
+	return strings.Join(quoted, " ")
 }
 
-func (d *Dirk) build(c *dagger.Container) *dagger.Container {
-	cmd := append(d.baseCommand(),
-		[]string{
-			"-projectPath",
-			"/src",
-			"-buildTarget",
-			d.BuildTarget,
-			"-customBuildPath",
-			"/builds/",
-			"-customBuildName",
-			d.BuildName,
-			"-customBuildTarget",
-			d.BuildTarget,
-			"-quit",
-			"-executeMethod",
-			"BuildCommand.PerformBuild",
-			"-logFile",
-			"/builds/unity.log",
-		}...,
-	)
+// withJunitResults mounts the JUnit transform next to the results and adds
+// the NUnit results converted with it
+func (d *Dirk) withJunitResults(c *dagger.Container) *dagger.Container {
+	jf := d.convertTestsToJUNIT(c.File(d.resultsPath()), d.JunitTransform)
 
 	return c.
-		WithExec(cmd,
-			dagger.ContainerWithExecOpts{
-				Expect: dagger.ReturnTypeAny,
-			},
-		)
+		WithFile("/nunit-transforms/nunit3-junit.xslt", d.JunitTransform).
+		WithFile(d.junitResultsPath(), jf)
 }
 
-func (d *Dirk) test(c *dagger.Container) *dagger.Container {
-	cmd := append(d.baseCommand(),
-		[]string{
-			"-projectPath",
-			"/src",
-			"-runTests",
-			"-testResults",
-			"/results/" + d.TestingingPlatform + "-results.xml",
-			"-debugCodeOptimization",
-			"-enableCodeCoverage",
-			"-coverageResultsPath",
-			"/results/" + d.TestingingPlatform + "-coverage/",
-			"-coverageHistoryPath",
-			"/results/" + d.TestingingPlatform + "-coverage-history/",
-			"-testPlatform",
-			d.TestingingPlatform,
-			"-coverageOptions",
-			"'generateAdditionalMetrics;generateHtmlReport;generateHtmlReportHistory;generateBadgeReport;verbosity:verbose'",
-			"-logFile",
-			"/results/unity.log",
-		}...)
-
-	return c.
-		WithExec(cmd,
-			dagger.ContainerWithExecOpts{
-				Expect: dagger.ReturnTypeAny,
-			},
-		)
+func (d *Dirk) convertTestsToJUNIT(f, transform *dagger.File) *dagger.File {
+	return d.saxonContainer().
+		WithFile("/results/"+d.TestingingPlatform+"-results.xml", f).
+		WithFile("/nunit-transforms/nunit3-junit.xslt", transform).
+		WithExec([]string{
+			"sh",
+			"-c",
+			"saxonb-xslt -s /results/" + d.TestingingPlatform + "-results.xml -xsl /nunit-transforms/nunit3-junit.xslt > /results/" + d.TestingingPlatform + "-junit-results.xml",
+		}).
+		File("/results/" + d.TestingingPlatform + "-junit-results.xml")
 }
 
-func (d *Dirk) getBuildArtifact(c *dagger.Container) *dagger.Directory {
-	return c.
-		Directory("/builds")
-}
+// saxonContainer provides saxonb-xslt. A custom image is expected to ship it
+// already; otherwise it is installed on eclipse-temurin, from the cached apt
+// downloads when possible so apt only goes online on a cache miss.
+func (d *Dirk) saxonContainer() *dagger.Container {
+	if d.SaxonImage != "" {
+		return dag.Container().From(d.SaxonImage)
+	}
 
-func (d *Dirk) getTestResults(c *dagger.Container) *dagger.Directory {
-	return c.
-		Directory("/results")
+	return dag.Container().From("eclipse-temurin").
+		WithMountedCache("/var/cache/apt/archives", dag.CacheVolume("apt-archives-eclipse-temurin")).
+		WithMountedCache("/var/lib/apt/lists", dag.CacheVolume("apt-lists-eclipse-temurin")).
+		WithExec([]string{
+			"rm",
+			"-f",
+			"/etc/apt/apt.conf.d/docker-clean",
+		}).
+		WithExec([]string{
+			"sh",
+			"-c",
+			"apt-get install -y --no-download libsaxonb-java || (apt-get update && apt-get install -y libsaxonb-java)",
+		})
 }
 
-func (d *Dirk) register(c *dagger.Container) *dagger.Container {
-	if d.Ulf != nil {
-		fmt.Println("Registering personal license")
-		c = d.registerPersonalLicense(c)
+// GameCI image version used when none is provided
+const defaultGameciVersion = "3.1.0"
+
+// image resolves the GameCI editor image, including the registry if any
+func (d *Dirk) image() (string, error) {
+	if d.GameciVersion == "" {
+		d.GameciVersion = defaultGameciVersion
 	}
 
-	if d.Serial != nil {
-		fmt.Println("Registering serial license")
-		c = d.registerSerialLicense(c)
+	image := "unityci/editor:" + d.Os + "-" + d.UnityVersion + "-" + d.Platform + "-" + d.GameciVersion
+
+	if d.Os == "" || d.UnityVersion == "" || d.Platform == "" {
+		return "", fmt.Errorf("incomplete image tag %s: os, unity version and platform must be set", image)
 	}
 
-	if d.ServiceConfig != nil {
-		fmt.Println("Registering license server")
-		c = d.registerLicenseServer(c)
+	if d.Registry != "" {
+		image = strings.TrimSuffix(d.Registry, "/") + "/" + image
 	}
 
-	return c
+	return image, nil
 }
 
-func (d *Dirk) registerPersonalLicense(c *dagger.Container) *dagger.Container {
+// withNetwork points every exec in the container, editor and licensing client
+// alike, at the configured proxies and DNS. Both spellings of the proxy
+// variables are set as tools disagree on which one they read.
+func (d *Dirk) withNetwork(c *dagger.Container) *dagger.Container {
+	for _, proxy := range []struct{ name, value string }{
+		{"HTTP_PROXY", d.HttpProxy},
+		{"HTTPS_PROXY", d.HttpsProxy},
+		{"NO_PROXY", d.NoProxy},
+	} {
+		if proxy.value == "" {
+			continue
+		}
 
-	cmd := append(d.baseCommand(),
-		[]string{
-			"-username",
-			"echo ${USER}",
-			"-password",
-			"echo ${PASS}",
-		}...,
-	)
+		// The value is not logged as proxy URLs often hold credentials
+		fmt.Println("Setting " + proxy.name)
 
-	return c.
-		WithFile("/root/.local/share/unity3d/Unity/Unity_lic.ulf", d.Ulf).
-		WithExec(cmd,
-			dagger.ContainerWithExecOpts{
-				Expect: dagger.ReturnTypeAny,
-			},
-		)
+		c = c.
+			WithEnvVariable(proxy.name, proxy.value).
+			WithEnvVariable(strings.ToLower(proxy.name), proxy.value)
+	}
+
+	if d.ResolvConf != nil {
+		fmt.Println("Using a custom resolv.conf")
+		c = c.WithMountedFile("/etc/resolv.conf", d.ResolvConf)
+	}
+
+	return c
 }
 
-func (d *Dirk) registerSerialLicense(c *dagger.Container) *dagger.Container {
-	ctx := context.Background()
-	s, err := d.Serial.Plaintext(ctx)
+// Container platforms the editor image can be pulled for
+var platformArchs = []string{"linux/amd64", "linux/arm64"}
 
-	if err != nil {
+// checkPlatformArch normalizes a bare architecture such as arm64 to a
+// container platform and rejects unknown ones. An empty one keeps the host's.
+func (d *Dirk) checkPlatformArch() error {
+	if d.PlatformArch == "" {
 		return nil
 	}
 
-	cmd := append(d.baseCommand(),
-		[]string{
-			"-username",
-			"echo ${USER}",
-			"-password",
-			"echo ${PASS}",
-			"-serial",
-			s,
-		}...,
-	)
-
-	return c.
-		WithExec(cmd,
-			dagger.ContainerWithExecOpts{
-				Expect: dagger.ReturnTypeAny,
-			},
-		)
-}
+	if !strings.Contains(d.PlatformArch, "/") {
+		d.PlatformArch = "linux/" + d.PlatformArch
+	}
 
-func (d *Dirk) registerLicenseServer(c *dagger.Container) *dagger.Container {
-	return c.WithFile("/usr/share/unity3d/config/services-config.json", d.ServiceConfig).
-		WithExec([]string{
-			"sh",
-			"-c",
-			"/opt/unity/Editor/Data/Resources/Licensing/Client/Unity.Licensing.Client --acquire-floating",
-		})
-}
+	if !slices.Contains(platformArchs, d.PlatformArch) {
+		return fmt.Errorf("invalid platform arch %q: expected linux/amd64 or linux/arm64", d.PlatformArch)
+	}
 
-func (d *Dirk) returnLicense(c *dagger.Container) *dagger.Container {
+	if d.PlatformArch == "linux/arm64" {
+		fmt.Println("Warning: unityci/editor images are only published for linux/amd64, pulling linux/arm64 fails unless the registry provides one")
+	}
 
-	cmd := append(d.baseCommand(), []string{"-returnlicense"}...)
-	return c.
-		WithExec(cmd, dagger.ContainerWithExecOpts{
-			Expect: dagger.ReturnTypeAny,
-		})
+	return nil
 }
 
-func (d *Dirk) checkForError() error {
+// checkRegistry rejects registry credentials without a registry, which would
+// otherwise pull from Docker Hub unauthenticated
+func (d *Dirk) checkRegistry() error {
+	if d.Registry == "" && (d.RegistryUser != "" || d.RegistryPass != nil) {
+		return fmt.Errorf("registry credentials provided without a registry: pass --registry as well")
+	}
+
 	return nil
 }
 
-func (d *Dirk) baseCommand() []string {
-	return []string{
-		"xvfb-run",
-		"--auto-servernum",
-		"--server-args='-screen 0 640x480x24'",
-		"unity-editor",
-		"-nographics",
+func (d *Dirk) createBaseImage() (*dagger.Container, error) {
+	image, err := d.image()
+
+	if err != nil {
+		return nil, err
 	}
-}
 
-func (d *Dirk) convertTestsToJUNIT(f, transform *dagger.File) *dagger.File {
-	return dag.Container().From("eclipse-temurin").
-		WithExec([]string{
-			"apt-get",
-			"update",
-		}).
-		WithExec([]string{
-			"apt-get",
-			"install",
-			"-y",
-			"libsaxonb-java",
-		}).
-		WithFile("/results/"+d.TestingingPlatform+"-results.xml", f).
-		WithFile("/nunit-transforms/nunit3-junit.xslt", transform).
-		WithExec([]string{
-			"sh",
-			"-c",
-			"saxonb-xslt -s /results/" + d.TestingingPlatform + "-results.xml -xsl /nunit-transforms/nunit3-junit.xslt > /results/" + d.TestingingPlatform + "-junit-results.xml",
-		}).
-		File("/results/" + d.TestingingPlatform + "-junit-results.xml")
-}
+	opts := dagger.ContainerOpts{}
+
+	if d.PlatformArch != "" {
+		fmt.Println("Using container platform " + d.PlatformArch)
+		opts.Platform = dagger.Platform(d.PlatformArch)
+	}
+
+	c := dag.Container(opts)
+
+	if d.Registry != "" && d.RegistryPass != nil {
+		c = c.WithRegistryAuth(d.Registry, d.RegistryUser, d.RegistryPass)
+	}
+
+	fmt.Println("Using image " + image)
+
+	c = c.From(image).
+		WithMountedCache(packageCachePath, dag.CacheVolume(d.packageCacheKey()))
+
+	c = d.withNetwork(c)
+
+	if d.Deterministic {
+		fmt.Println("Deterministic mode, using the UTC timezone, the C.UTF-8 locale and deterministic compilation")
+
+		c = c.
+			WithEnvVariable("TZ", "UTC").
+			WithEnvVariable("LANG", "C.UTF-8").
+			WithEnvVariable("LC_ALL", "C.UTF-8").
+			// Read by BuildCommand.HandleDeterministic and BuildCommand.BuildAssetBundles
+			WithEnvVariable("DETERMINISTIC", "true")
+	}
+
+	if d.NoCache {
+		c = c.WithEnvVariable("CACHEBUSTER", time.Now().String())
+	}
 
-func (d *Dirk) createBaseImage() *dagger.Container {
-	return dag.Container().From("unityci/editor:" + d.Os + "-" + d.UnityVersion + "-" + d.Platform + "-" + d.GameciVersion)
+	return c, nil
 }
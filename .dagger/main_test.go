@@ -0,0 +1,483 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRedactCommand(t *testing.T) {
+	tests := []struct {
+		name string
+		cmd  []string
+		want []string
+	}{
+		{
+			name: "credentials",
+			cmd:  []string{"unity-editor", "-username", "someone", "-password", "hunter2", "-serial", "SC-1234"},
+			want: []string{"unity-editor", "-username", "***", "-password", "***", "-serial", "***"},
+		},
+		{
+			name: "flags in any case",
+			cmd:  []string{"unity-editor", "-Password", "hunter2", "-SERIAL", "SC-1234"},
+			want: []string{"unity-editor", "-Password", "***", "-SERIAL", "***"},
+		},
+		{
+			name: "other flags",
+			cmd:  []string{"unity-editor", "-batchmode", "-buildTarget", "Android"},
+			want: []string{"unity-editor", "-batchmode", "-buildTarget", "Android"},
+		},
+		{
+			name: "flag without a value",
+			cmd:  []string{"unity-editor", "-password"},
+			want: []string{"unity-editor", "-password"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := slices.Clone(tt.cmd)
+
+			if got := redactCommand(cmd); !slices.Equal(got, tt.want) {
+				t.Errorf("redactCommand(%q) = %q, want %q", tt.cmd, got, tt.want)
+			}
+
+			if !slices.Equal(cmd, tt.cmd) {
+				t.Errorf("redactCommand modified its argument: %q", cmd)
+			}
+		})
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{
+			name: "plain",
+			args: []string{"unity-editor", "-batchmode"},
+			want: `'unity-editor' '-batchmode'`,
+		},
+		{
+			name: "spaces and shell syntax",
+			args: []string{"-executeMethod", "Build $HOME; rm -rf /"},
+			want: `'-executeMethod' 'Build $HOME; rm -rf /'`,
+		},
+		{
+			name: "single quotes",
+			args: []string{"--server-args='-screen 0 640x480x24'"},
+			want: `'--server-args='\''-screen 0 640x480x24'\'''`,
+		},
+		{
+			name: "empty argument",
+			args: []string{"-define", ""},
+			want: `'-define' ''`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := shellQuote(tt.args); got != tt.want {
+				t.Errorf("shellQuote(%q) = %s, want %s", tt.args, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLicenseCommandsOnlyReferenceCredentials(t *testing.T) {
+	d := &Dirk{User: "someone@example.com"}
+
+	tests := []struct {
+		name string
+		cmd  []string
+		refs []string
+	}{
+		{
+			name: "personal",
+			cmd:  d.personalLicenseCommand(),
+			refs: []string{`-username "$USER"`, `-password "$PASS"`, "-quit -logFile /dev/stdout"},
+		},
+		{
+			name: "serial",
+			cmd:  d.serialLicenseCommand(),
+			refs: []string{`-username "$USER"`, `-password "$PASS"`, `-serial "$SERIAL"`, "-quit -logFile /dev/stdout"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if len(tt.cmd) != 3 || tt.cmd[0] != "sh" || tt.cmd[1] != "-c" {
+				t.Fatalf("expected a sh -c command, got %q", tt.cmd)
+			}
+
+			for _, ref := range tt.refs {
+				if !strings.Contains(tt.cmd[2], ref) {
+					t.Errorf("command %q does not contain %s", tt.cmd[2], ref)
+				}
+			}
+
+			for _, arg := range tt.cmd {
+				if strings.Contains(arg, d.User) {
+					t.Errorf("command contains the user: %q", arg)
+				}
+			}
+
+			// Only the shell may expand the credentials, so the flags must
+			// never be passed as separate arguments with their values
+			for _, flag := range secretFlags {
+				if slices.Contains(tt.cmd, flag) {
+					t.Errorf("command passes %s as an argument: %q", flag, tt.cmd)
+				}
+			}
+		})
+	}
+}
+
+type fakeFile struct {
+	contents string
+	err      error
+}
+
+func (f fakeFile) Contents(context.Context) (string, error) {
+	return f.contents, f.err
+}
+
+func TestReadProjectVersionMissingFile(t *testing.T) {
+	_, err := readProjectVersion(context.Background(), fakeFile{err: errors.New("no such file or directory")})
+
+	if err == nil || !strings.Contains(err.Error(), "could not read ProjectSettings/ProjectVersion.txt") {
+		t.Errorf("expected a read error, got %v", err)
+	}
+}
+
+func TestParseProjectVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		want     string
+		err      string
+	}{
+		{
+			name:     "version",
+			contents: "m_EditorVersion: 2022.3.10f1\n",
+			want:     "2022.3.10f1",
+		},
+		{
+			name:     "revision",
+			contents: "m_EditorVersion: 2022.3.10f1\nm_EditorVersionWithRevision: 2022.3.10f1 (ff3792e53c62)\n",
+			want:     "2022.3.10f1",
+		},
+		{
+			name:     "revision on the version",
+			contents: "m_EditorVersion: 2022.3.10f1 (ff3792e53c62)\r\n",
+			want:     "2022.3.10f1",
+		},
+		{
+			name:     "missing key",
+			contents: "m_EditorVersionWithRevision: 2022.3.10f1 (ff3792e53c62)\n",
+			err:      "m_EditorVersion not found",
+		},
+		{
+			name:     "empty value",
+			contents: "m_EditorVersion: \n",
+			err:      "m_EditorVersion is empty",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseProjectVersion(tt.contents)
+
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("parseProjectVersion(%q) error = %v, want %q", tt.contents, err, tt.err)
+				}
+
+				return
+			}
+
+			if err != nil || got != tt.want {
+				t.Errorf("parseProjectVersion(%q) = %q, %v, want %q", tt.contents, got, err, tt.want)
+			}
+		})
+	}
+}
+
+// requireEngine skips tests that need a Dagger engine unless they run in a
+// session, e.g. under dagger run go test ./...
+func requireEngine(t *testing.T) {
+	t.Helper()
+
+	if os.Getenv("DAGGER_SESSION_PORT") == "" {
+		t.Skip("no Dagger session, run with dagger run go test ./...")
+	}
+}
+
+const nunitResults = `<?xml version="1.0" encoding="utf-8"?>
+<test-run testcasecount="1" passed="1" failed="0" skipped="0" duration="0.1">
+  <test-suite type="TestFixture" name="Tests" testcasecount="1" passed="1" failed="0" skipped="0" duration="0.1">
+    <test-case name="Passes" classname="Tests" result="Passed" duration="0.1" asserts="1"/>
+  </test-suite>
+</test-run>
+`
+
+func TestWithJunitResults(t *testing.T) {
+	requireEngine(t)
+
+	ctx := context.Background()
+	xslt, err := os.ReadFile("nunit3-junit.xslt")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := &Dirk{
+		TestingingPlatform: "editmode",
+		JunitTransform:     dag.Directory().WithNewFile("nunit3-junit.xslt", string(xslt)).File("nunit3-junit.xslt"),
+	}
+
+	c := d.withJunitResults(dag.Container().
+		From("alpine").
+		WithNewFile(d.resultsPath(), nunitResults))
+
+	if _, err := c.File("/nunit-transforms/nunit3-junit.xslt").Contents(ctx); err != nil {
+		t.Errorf("the JUnit transform is not in the container: %v", err)
+	}
+
+	junit, err := c.File(d.junitResultsPath()).Contents(ctx)
+
+	if err != nil {
+		t.Fatalf("no JUnit results: %v", err)
+	}
+
+	if !strings.Contains(junit, `<testcase name="Passes"`) {
+		t.Errorf("unexpected JUnit results:\n%s", junit)
+	}
+}
+
+func TestCheckScopedDefines(t *testing.T) {
+	tests := []struct {
+		name    string
+		defines []string
+		err     bool
+	}{
+		{name: "unscoped", defines: []string{"ENABLE_CHEATS"}},
+		{name: "scoped", defines: []string{"Server:ENABLE_SERVER", "Android:MOBILE"}},
+		{name: "missing target", defines: []string{":ENABLE_SERVER"}, err: true},
+		{name: "missing symbol", defines: []string{"Server:"}, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkScopedDefines(tt.defines); (err != nil) != tt.err {
+				t.Errorf("checkScopedDefines(%q) = %v, want an error: %t", tt.defines, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCheckScriptingBackend(t *testing.T) {
+	tests := []struct {
+		name    string
+		target  string
+		backend string
+		err     bool
+	}{
+		{name: "project setting", target: "iOS"},
+		{name: "il2cpp", target: "StandaloneLinux64", backend: "il2cpp"},
+		{name: "mono", target: "Android", backend: "mono2x"},
+		{name: "unknown backend", target: "Android", backend: "dotnet", err: true},
+		{name: "mono on an il2cpp only target", target: "WebGL", backend: "mono2x", err: true},
+		{name: "target in any case", target: "ios", backend: "mono2x", err: true},
+		{name: "il2cpp on an il2cpp only target", target: "iOS", backend: "il2cpp"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkScriptingBackend(tt.target, tt.backend); (err != nil) != tt.err {
+				t.Errorf("checkScriptingBackend(%q, %q) = %v, want an error: %t", tt.target, tt.backend, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestUnityVersionAtLeast(t *testing.T) {
+	tests := []struct {
+		version string
+		major   int
+		minor   int
+		want    bool
+	}{
+		{version: "2021.3.10f1", major: 2021, minor: 2, want: true},
+		{version: "2021.2.0f1", major: 2021, minor: 2, want: true},
+		{version: "2021.1.28f1", major: 2021, minor: 2, want: false},
+		{version: "2020.3.48f1", major: 2021, minor: 2, want: false},
+		{version: "6000.0.29f1", major: 2023, minor: 1, want: true},
+		{version: "2022", major: 2021, minor: 2, want: false},
+		{version: "", major: 2021, minor: 2, want: false},
+		{version: "latest.1", major: 2021, minor: 2, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			if got := unityVersionAtLeast(tt.version, tt.major, tt.minor); got != tt.want {
+				t.Errorf("unityVersionAtLeast(%q, %d, %d) = %t, want %t", tt.version, tt.major, tt.minor, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckArchitecture(t *testing.T) {
+	tests := []struct {
+		name         string
+		target       string
+		architecture string
+		version      string
+		err          bool
+	}{
+		{name: "project setting", target: "StandaloneOSX", version: "2019.4.40f1"},
+		{name: "matrix", architecture: "arm64", version: "2022.3.10f1"},
+		{name: "unknown architecture", target: "StandaloneOSX", architecture: "x86", version: "2022.3.10f1", err: true},
+		{name: "universal macOS", target: "StandaloneOSX", architecture: "universal", version: "2022.3.10f1"},
+		{name: "arm64 macOS before 2020.2", target: "StandaloneOSX", architecture: "arm64", version: "2020.1.17f1", err: true},
+		{name: "x64 macOS before 2020.2", target: "StandaloneOSX", architecture: "x64", version: "2019.4.40f1"},
+		{name: "arm64 Windows", target: "StandaloneWindows64", architecture: "arm64", version: "2023.1.0f1"},
+		{name: "arm64 Windows before 2023.1", target: "StandaloneWindows64", architecture: "arm64", version: "2022.3.10f1", err: true},
+		{name: "universal Windows", target: "StandaloneWindows64", architecture: "universal", version: "6000.0.29f1", err: true},
+		{name: "arm64 Linux", target: "StandaloneLinux64", architecture: "arm64", version: "6000.0.29f1"},
+		{name: "arm64 Linux before 6000.0", target: "StandaloneLinux64", architecture: "arm64", version: "2022.3.10f1", err: true},
+		{name: "universal Linux", target: "StandaloneLinux64", architecture: "universal", version: "6000.0.29f1", err: true},
+		{name: "non standalone target", target: "Android", architecture: "x64", version: "6000.0.29f1", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Dirk{Architecture: tt.architecture, UnityVersion: tt.version}
+
+			if err := d.checkArchitecture(tt.target); (err != nil) != tt.err {
+				t.Errorf("checkArchitecture(%q) with %q on %s = %v, want an error: %t", tt.target, tt.architecture, tt.version, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestBuildPath(t *testing.T) {
+	tests := []struct {
+		name   string
+		layout string
+		root   string
+		want   string
+	}{
+		{name: "flat", root: "/builds/", want: "/builds/"},
+		{name: "nested", layout: "nested", root: "/builds/", want: "/builds/StandaloneLinux64/game/"},
+		{name: "nested target directory", layout: "nested", root: "/builds/StandaloneLinux64/", want: "/builds/StandaloneLinux64/game/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Dirk{OutputLayout: tt.layout, BuildTarget: "StandaloneLinux64", BuildName: "game"}
+
+			if got := d.buildPath(tt.root); got != tt.want {
+				t.Errorf("buildPath(%q) = %q, want %q", tt.root, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogPath(t *testing.T) {
+	tests := []struct {
+		name    string
+		logPath string
+		def     string
+		prefix  string
+		want    string
+	}{
+		{name: "default", def: "/results/unity.log", want: "/results/unity.log"},
+		{name: "default with a prefix", def: "/results/unity.log", prefix: "editmode-", want: "/results/editmode-unity.log"},
+		{name: "given", logPath: "/logs/editor.log", def: "/results/unity.log", want: "/logs/editor.log"},
+		{name: "given with a prefix", logPath: "/logs/editor.log", def: "/results/unity.log", prefix: "test-", want: "/logs/test-editor.log"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Dirk{LogPath: tt.logPath}
+
+			if got := d.logPath(tt.def, tt.prefix); got != tt.want {
+				t.Errorf("logPath(%q, %q) = %q, want %q", tt.def, tt.prefix, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildLogPath(t *testing.T) {
+	tests := []struct {
+		name    string
+		logPath string
+		target  string
+		want    string
+	}{
+		{name: "default", target: "Android", want: "/builds/unity.log"},
+		{name: "given", logPath: "/logs/editor.log", target: "Android", want: "/logs/Android-editor.log"},
+		{name: "given without a target", logPath: "/logs/editor.log", want: "/logs/editor.log"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &Dirk{LogPath: tt.logPath, BuildTarget: tt.target}
+
+			if got := d.buildLogPath("/builds/"); got != tt.want {
+				t.Errorf("buildLogPath(%q) = %q, want %q", "/builds/", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompilerDiagnostics(t *testing.T) {
+	log := `Compiling assemblies
+Assets/Scripts/Player.cs(12,9): warning CS0168: The variable 'e' is declared but never used
+Assets/Scripts/Enemy.cs(3,10): error CS0246: The type or namespace name 'Foo' could not be found
+  Assets/Scripts/Player.cs(12,9): warning CS0168: The variable 'e' is declared but never used
+Assets/Scripts/Menu.cs(40,5): warning CS0414: The field 'Menu.count' is assigned but its value is never used
+Debug.LogWarning: no warning CS here
+`
+
+	tests := []struct {
+		name    string
+		pattern *regexp.Regexp
+		want    []string
+	}{
+		{
+			name:    "warnings",
+			pattern: compilerWarningPattern,
+			want: []string{
+				"Assets/Scripts/Player.cs(12,9): warning CS0168: The variable 'e' is declared but never used",
+				"Assets/Scripts/Menu.cs(40,5): warning CS0414: The field 'Menu.count' is assigned but its value is never used",
+			},
+		},
+		{
+			name:    "errors",
+			pattern: compilerErrorPattern,
+			want: []string{
+				"Assets/Scripts/Enemy.cs(3,10): error CS0246: The type or namespace name 'Foo' could not be found",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := compilerDiagnostics(log, tt.pattern); !slices.Equal(got, tt.want) {
+				t.Errorf("compilerDiagnostics() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if got := compilerDiagnostics("Compilation succeeded\n", compilerErrorPattern); len(got) != 0 {
+		t.Errorf("compilerDiagnostics() = %q, want none", got)
+	}
+}
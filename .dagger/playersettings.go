@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+)
+
+// Player settings overrides, as read by BuildCommand.HandlePlayerSettings.
+// Fields left out keep the project's settings.
+type playerSettings struct {
+	CompanyName string `json:"companyName"`
+	ProductName string `json:"productName"`
+	Icon        string `json:"icon"`
+	Orientation string `json:"orientation"`
+}
+
+// Values of Unity's UIOrientation accepted as the default orientation
+var playerOrientations = []string{
+	"Portrait",
+	"PortraitUpsideDown",
+	"LandscapeLeft",
+	"LandscapeRight",
+	"AutoRotation",
+}
+
+// checkPlayerSettings fails unless the player settings file parses, so a typo
+// is reported before the editor starts rather than silently ignored
+func (d *Dirk) checkPlayerSettings(ctx context.Context) error {
+	contents, err := d.PlayerSettings.Contents(ctx)
+
+	if err != nil {
+		return fmt.Errorf("could not read player settings: %w", err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader([]byte(contents)))
+	decoder.DisallowUnknownFields()
+
+	var settings playerSettings
+
+	if err := decoder.Decode(&settings); err != nil {
+		return fmt.Errorf("invalid player settings: %w", err)
+	}
+
+	if settings.Orientation != "" && !slices.Contains(playerOrientations, settings.Orientation) {
+		return fmt.Errorf("invalid player settings orientation %q: expected one of %v", settings.Orientation, playerOrientations)
+	}
+
+	return nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/bardic/Dirk/internal/dagger"
+)
+
+// Summary of a Unity build, matching the report written by
+// BuildCommand.WriteBuildReport
+type buildReport struct {
+	Result    string             `json:"result"`
+	TotalSize int64              `json:"totalSize"`
+	TotalTime float64            `json:"totalTime"`
+	Assets    []buildReportAsset `json:"assets,omitempty"`
+}
+
+type buildReportAsset struct {
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+}
+
+var (
+	buildResultPattern = regexp.MustCompile(`Build completed with a result of '(\w+)' in \d+ seconds \((\d+) ms\)`)
+	buildSizePattern   = regexp.MustCompile(`(?i)Complete build size ([\d.]+) (kb|mb|gb)`)
+)
+
+// Multipliers for the size units Unity prints in its build report
+var sizeUnits = map[string]float64{
+	"kb": 1 << 10,
+	"mb": 1 << 20,
+	"gb": 1 << 30,
+}
+
+// withBuildReport makes sure buildPath contains a build-report.json. The
+// report written by the build method is kept as is, otherwise one is parsed
+// from the Unity log. When neither works an empty object is written.
+func (d *Dirk) withBuildReport(ctx context.Context, c *dagger.Container, buildPath string) *dagger.Container {
+	reportPath := buildPath + "build-report.json"
+
+	if _, err := c.File(reportPath).Contents(ctx); err == nil {
+		return c
+	}
+
+	report := "{}"
+	r, err := readBuildReport(ctx, c, d.buildLogPath(buildPath))
+
+	if err != nil {
+		fmt.Printf("Warning: could not produce a build report: %v\n", err)
+	} else {
+		b, err := json.MarshalIndent(r, "", "  ")
+
+		if err != nil {
+			fmt.Printf("Warning: could not produce a build report: %v\n", err)
+		} else {
+			report = string(b)
+		}
+	}
+
+	return c.WithNewFile(reportPath, report)
+}
+
+// readBuildReport builds a report from the Unity log at logPath
+func readBuildReport(ctx context.Context, c *dagger.Container, logPath string) (*buildReport, error) {
+	log, err := c.File(logPath).Contents(ctx)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", logPath, err)
+	}
+
+	r, err := parseBuildReport(log)
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", logPath, err)
+	}
+
+	return r, nil
+}
+
+// parseBuildReport builds a report from the summary lines in the Unity log
+func parseBuildReport(log string) (*buildReport, error) {
+	m := buildResultPattern.FindStringSubmatch(log)
+
+	if m == nil {
+		return nil, fmt.Errorf("no build result found")
+	}
+
+	ms, _ := strconv.Atoi(m[2])
+
+	r := &buildReport{
+		Result:    m[1],
+		TotalTime: float64(ms) / 1000,
+	}
+
+	if m := buildSizePattern.FindStringSubmatch(log); m != nil {
+		size, _ := strconv.ParseFloat(m[1], 64)
+		r.TotalSize = int64(size * sizeUnits[strings.ToLower(m[2])])
+	}
+
+	return r, nil
+}
+
+// Size of a build, written to build-metrics.json so it can be tracked across
+// builds
+type buildMetrics struct {
+	BuildTarget  string `json:"buildTarget"`
+	TotalSize    int64  `json:"totalSize"`
+	MaxBuildSize int64  `json:"maxBuildSize,omitempty"`
+}
+
+// withBuildMetrics measures the build in buildPath, leaving out the Unity
+// log, and writes build-metrics.json next to it. It fails when the build is
+// larger than the size budget.
+func (d *Dirk) withBuildMetrics(ctx context.Context, c *dagger.Container, buildPath string) (*dagger.Container, error) {
+	if !d.BuildMetrics && d.MaxBuildSize == 0 {
+		return c, nil
+	}
+
+	out, err := c.WithExec([]string{
+		"du",
+		"-sb",
+		"--exclude=" + path.Base(d.buildLogPath(buildPath)),
+		buildPath,
+	}).Stdout(ctx)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not measure the build: %w", err)
+	}
+
+	fields := strings.Fields(out)
+
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("could not measure the build: no output from du")
+	}
+
+	size, err := strconv.ParseInt(fields[0], 10, 64)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not measure the build: %w", err)
+	}
+
+	m := buildMetrics{
+		BuildTarget:  d.BuildTarget,
+		TotalSize:    size,
+		MaxBuildSize: int64(d.MaxBuildSize) << 20,
+	}
+
+	b, err := json.MarshalIndent(m, "", "  ")
+
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("Build size %.1f MB\n", float64(size)/(1<<20))
+
+	c = c.WithNewFile(buildPath+"build-metrics.json", string(b))
+
+	if m.MaxBuildSize > 0 && m.TotalSize > m.MaxBuildSize {
+		return nil, fmt.Errorf("build size %.1f MB exceeds the %d MB budget", float64(size)/(1<<20), d.MaxBuildSize)
+	}
+
+	return c, nil
+}
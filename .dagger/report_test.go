@@ -0,0 +1,60 @@
+package main
+
+import "testing"
+
+func TestParseBuildReport(t *testing.T) {
+	tests := []struct {
+		name string
+		log  string
+		want *buildReport
+	}{
+		{
+			name: "succeeded",
+			log: `Build Report
+Uncompressed usage by category (Percentages based on user generated assets only):
+Textures               8.0 mb	 61.5%
+Complete build size 146.2 mb
+Build completed with a result of 'Succeeded' in 42 seconds (41837 ms)
+`,
+			want: &buildReport{Result: "Succeeded", TotalSize: 153301811, TotalTime: 41.837},
+		},
+		{
+			name: "size in kb",
+			log: `Complete build size 512 KB
+Build completed with a result of 'Succeeded' in 3 seconds (3120 ms)
+`,
+			want: &buildReport{Result: "Succeeded", TotalSize: 524288, TotalTime: 3.12},
+		},
+		{
+			name: "failed without a size",
+			log:  "Build completed with a result of 'Failed' in 7 seconds (6500 ms)\n",
+			want: &buildReport{Result: "Failed", TotalTime: 6.5},
+		},
+		{
+			name: "no build result",
+			log:  "Compilation failed: 1 error(s)\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseBuildReport(tt.log)
+
+			if tt.want == nil {
+				if err == nil {
+					t.Errorf("parseBuildReport() = %+v, want an error", *got)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got.Result != tt.want.Result || got.TotalSize != tt.want.TotalSize || got.TotalTime != tt.want.TotalTime {
+				t.Errorf("parseBuildReport() = %+v, want %+v", *got, *tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/bardic/Dirk/internal/dagger"
+)
+
+// Totals of the top-level test-run element of an NUnit 3 results file
+type testRun struct {
+	Total        int     `xml:"total,attr"`
+	Passed       int     `xml:"passed,attr"`
+	Failed       int     `xml:"failed,attr"`
+	Skipped      int     `xml:"skipped,attr"`
+	Inconclusive int     `xml:"inconclusive,attr"`
+	Duration     float64 `xml:"duration,attr"`
+	Result       string  `xml:"result,attr"`
+}
+
+// Test counts of a run
+type TestSummary struct {
+	Total        int     // Test cases run
+	Passed       int     // Test cases that passed
+	Failed       int     // Test cases that failed
+	Skipped      int     // Test cases that were skipped or ignored
+	Inconclusive int     // Test cases without a result
+	Retried      int     // Test cases that failed, then passed on a retry
+	Duration     float64 // Duration of the run in seconds
+}
+
+// parseTestRun reads the test-run totals from the NUnit results at path
+func (d *Dirk) parseTestRun(ctx context.Context, c *dagger.Container, path string) (testRun, error) {
+	var run testRun
+
+	s, err := c.File(path).Contents(ctx)
+
+	if err != nil {
+		return run, fmt.Errorf("could not read %s: %w", path, err)
+	}
+
+	if err := xml.Unmarshal([]byte(s), &run); err != nil {
+		return run, fmt.Errorf("could not parse %s: %w", path, err)
+	}
+
+	return run, nil
+}
+
+// checkTestResults fails when any test case failed. Unity exits with a
+// non-zero code on failures but still writes the results, so the outcome is
+// read from the results rather than the exit code.
+func (d *Dirk) checkTestResults(ctx context.Context, c *dagger.Container, path string) error {
+	run, err := d.parseTestRun(ctx, c, path)
+
+	if err != nil {
+		return err
+	}
+
+	d.Summary = &TestSummary{
+		Total:        run.Total,
+		Passed:       run.Passed,
+		Failed:       run.Failed,
+		Skipped:      run.Skipped,
+		Inconclusive: run.Inconclusive,
+		Duration:     run.Duration,
+	}
+
+	fmt.Printf("%d tests, %d passed, %d failed, %d skipped\n", run.Total, run.Passed, run.Failed, run.Skipped)
+
+	if run.Failed > 0 || strings.HasPrefix(run.Result, "Failed") {
+		return fmt.Errorf("%d of %d tests failed", run.Failed, run.Total)
+	}
+
+	return nil
+}
+
+// failedTests lists the full names of the failed test cases in the NUnit
+// results at path
+func (d *Dirk) failedTests(ctx context.Context, c *dagger.Container, path string) ([]string, error) {
+	s, err := c.File(path).Contents(ctx)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not read %s: %w", path, err)
+	}
+
+	var failed []string
+
+	decoder := xml.NewDecoder(strings.NewReader(s))
+
+	for {
+		token, err := decoder.Token()
+
+		if errors.Is(err, io.EOF) {
+			return failed, nil
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("could not parse %s: %w", path, err)
+		}
+
+		e, ok := token.(xml.StartElement)
+
+		if !ok || e.Name.Local != "test-case" {
+			continue
+		}
+
+		var name, result string
+
+		for _, attr := range e.Attr {
+			switch attr.Name.Local {
+			case "fullname":
+				name = attr.Value
+			case "result":
+				result = attr.Value
+			}
+		}
+
+		if result == "Failed" && name != "" {
+			failed = append(failed, name)
+		}
+	}
+}
+
+// retryFailedTests re-runs only the failed tests of the results at
+// resultsPath, up to the retry count, and counts the tests that pass on a
+// retry as passed. Every retry writes its own results and log next to the
+// originals, e.g. editmode-results-retry-1.xml.
+func (d *Dirk) retryFailedTests(ctx context.Context, c *dagger.Container, resultsPath string, logPath string) (*dagger.Container, error) {
+	failed, err := d.failedTests(ctx, c, resultsPath)
+
+	if err != nil {
+		return c, err
+	}
+
+	// Failures outside of test cases, e.g. in a fixture setup, can't be
+	// retried on their own
+	if len(failed) == 0 {
+		return c, fmt.Errorf("%d of %d tests failed", d.Summary.Failed, d.Summary.Total)
+	}
+
+	initial := len(failed)
+
+	for attempt := 1; attempt <= d.RetryFailed && len(failed) > 0; attempt++ {
+		fmt.Printf("Retrying %d failed test(s), attempt %d/%d\n", len(failed), attempt, d.RetryFailed)
+
+		retryResultsPath := strings.TrimSuffix(resultsPath, ".xml") + "-retry-" + strconv.Itoa(attempt) + ".xml"
+		retryLogPath := path.Join(path.Dir(logPath), "retry-"+strconv.Itoa(attempt)+"-"+path.Base(logPath))
+
+		// Anchored so a test doesn't also select the tests its name prefixes
+		filter := make([]string, len(failed))
+
+		for i, name := range failed {
+			filter[i] = "^" + regexp.QuoteMeta(name) + "$"
+		}
+
+		cmd := append(d.testCommand(retryResultsPath), "-testFilter", strings.Join(filter, ";"))
+
+		if assemblies := d.testAssemblies(); assemblies != "" {
+			cmd = append(cmd, "-assemblyNames", assemblies)
+		}
+
+		cmd = d.withExtraArgs(cmd)
+		cmd = d.withLogFile(cmd, retryLogPath)
+
+		c, err = d.runStep(ctx, c.WithExec(cmd, dagger.ContainerWithExecOpts{
+			Expect: dagger.ReturnTypeAny,
+		}), "test retry")
+
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := c.File(retryResultsPath).Sync(ctx); err != nil {
+			return c, fmt.Errorf("test retry never ran: %s was not written, check %s", retryResultsPath, retryLogPath)
+		}
+
+		failed, err = d.failedTests(ctx, c, retryResultsPath)
+
+		if err != nil {
+			return c, err
+		}
+	}
+
+	retried := initial - len(failed)
+
+	d.Summary.Passed += retried
+	d.Summary.Failed -= retried
+	d.Summary.Retried = retried
+
+	fmt.Printf("%d test(s) passed on a retry, %d still failing\n", retried, len(failed))
+
+	if len(failed) > 0 {
+		return c, fmt.Errorf("%d of %d tests failed after %d retries: %s", len(failed), d.Summary.Total, d.RetryFailed, strings.Join(failed, ", "))
+	}
+
+	return c, nil
+}
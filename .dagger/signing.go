@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/bardic/Dirk/internal/dagger"
+)
+
+// Keychain the signing certificate is imported into for the build
+const signingKeychain = "dirk-signing.keychain"
+
+// signsMac reports whether any of targets is a macOS standalone build that
+// should be codesigned. Other targets ignore the signing settings.
+func (d *Dirk) signsMac(targets []string) bool {
+	if d.SigningIdentity == "" {
+		return false
+	}
+
+	for _, target := range targets {
+		if strings.EqualFold(target, "StandaloneOSX") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkMacSigning fails before any license is activated when signing is
+// requested but the editor image lacks the macOS security and codesign
+// tools, which is the case on Linux and Windows runners
+func (d *Dirk) checkMacSigning(ctx context.Context, targets []string) error {
+	if !d.signsMac(targets) {
+		return nil
+	}
+
+	c, err := d.createBaseImage()
+
+	if err != nil {
+		return err
+	}
+
+	exitCode, err := c.WithExec([]string{"sh", "-c", "command -v security && command -v codesign"},
+		dagger.ContainerWithExecOpts{
+			Expect: dagger.ReturnTypeAny,
+		},
+	).ExitCode(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	if exitCode != 0 {
+		return fmt.Errorf("macOS code signing requires a macOS runner: security and codesign are not available in the %s image", d.Os)
+	}
+
+	return nil
+}
+
+// signMacBuild imports the signing certificate into a temporary keychain and
+// codesigns the .app written to buildPath
+func (d *Dirk) signMacBuild(ctx context.Context, c *dagger.Container, buildPath string) (*dagger.Container, error) {
+	if !d.signsMac([]string{d.BuildTarget}) {
+		return c, nil
+	}
+
+	app := buildPath + d.BuildName + ".app"
+
+	fmt.Println("Signing " + app + " as " + d.SigningIdentity)
+
+	c = c.
+		WithFile("/dirk/signing.p12", d.SigningCert).
+		WithEnvVariable("SIGNING_IDENTITY", d.SigningIdentity)
+
+	if d.SigningCertPass != nil {
+		c = c.WithSecretVariable("SIGNING_CERT_PASS", d.SigningCertPass)
+	}
+
+	script := strings.Join([]string{
+		"set -e",
+		"security create-keychain -p dirk " + signingKeychain,
+		"security unlock-keychain -p dirk " + signingKeychain,
+		`security import /dirk/signing.p12 -k ` + signingKeychain + ` -P "$SIGNING_CERT_PASS" -T /usr/bin/codesign`,
+		"security set-key-partition-list -S apple-tool:,apple: -s -k dirk " + signingKeychain,
+		`codesign --deep --force --options runtime --timestamp --keychain ` + signingKeychain + ` --sign "$SIGNING_IDENTITY" '` + app + `'`,
+		"codesign --verify --deep --strict '" + app + "'",
+	}, "; ")
+
+	c, err := d.runStep(ctx, c.WithExec([]string{"sh", "-c", script},
+		dagger.ContainerWithExecOpts{
+			Expect: dagger.ReturnTypeAny,
+		},
+	), "code signing")
+
+	if err != nil {
+		return nil, err
+	}
+
+	exitCode, err := c.ExitCode(ctx)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if exitCode != 0 {
+		stderr, _ := c.Stderr(ctx)
+		return nil, fmt.Errorf("code signing exited with code %d: %s", exitCode, strings.TrimSpace(stderr))
+	}
+
+	return c, nil
+}
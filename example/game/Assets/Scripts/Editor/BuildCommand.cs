@@ -2,6 +2,7 @@ using UnityEditor;
 using System.Linq;
 using System;
 using System.IO;
+using System.Reflection;
 
 static class BuildCommand
 {
@@ -12,9 +13,19 @@ static class BuildCommand
     private const string BUILD_OPTIONS_ENV_VAR = "BuildOptions";
     private const string ANDROID_BUNDLE_VERSION_CODE = "VERSION_BUILD_VAR";
     private const string ANDROID_APP_BUNDLE = "BUILD_APP_BUNDLE";
+    private const string ARCHITECTURE = "ARCHITECTURE";
+    private const string BOOT_SCENE = "BOOT_SCENE";
+    private const string BUILD_SCENES = "BUILD_SCENES";
+    private const string BUILD_SERVER = "BUILD_SERVER";
+    private const string DETERMINISTIC = "DETERMINISTIC";
+    private const string IL2CPP_ARGS = "IL2CPP_ARGS";
+    private const string PLAYER_SETTINGS = "PLAYER_SETTINGS";
+    private const string SCOPED_DEFINES = "SCOPED_DEFINES";
     private const string SCRIPTING_BACKEND_ENV_VAR = "SCRIPTING_BACKEND";
+    private const string TEXTURE_COMPRESSION = "TEXTURE_COMPRESSION";
     private const string VERSION_NUMBER_VAR = "VERSION_NUMBER_VAR";
     private const string VERSION_iOS = "VERSION_BUILD_VAR";
+    private const string WEBGL_COMPRESSION = "WEBGL_COMPRESSION";
     
     static string GetArgument(string name)
     {
@@ -31,6 +42,26 @@ static class BuildCommand
 
     static string[] GetEnabledScenes()
     {
+        var paths = GetSceneList();
+
+        if (TryGetEnv(BOOT_SCENE, out string bootScene))
+        {
+            Console.WriteLine($":: Booting into {bootScene}");
+            paths = new[] { bootScene }.Concat(paths.Where(path => path != bootScene)).ToArray();
+        }
+
+        return paths;
+    }
+
+    static string[] GetSceneList()
+    {
+        if (TryGetEnv(BUILD_SCENES, out string scenes))
+        {
+            var paths = scenes.Split(';');
+            Console.WriteLine($":: Building scenes from {BUILD_SCENES}: {string.Join(", ", paths)}");
+            return paths;
+        }
+
         return (
             from scene in EditorBuildSettings.scenes
             where scene.enabled
@@ -168,35 +199,368 @@ static class BuildCommand
         Console.WriteLine(":: Performing build");
         if (TryGetEnv(VERSION_NUMBER_VAR, out var bundleVersionNumber))
         {
-            if (buildTarget == BuildTarget.iOS)
-            {
-                bundleVersionNumber = GetIosVersion();
-            }
             Console.WriteLine($":: Setting bundleVersionNumber to '{bundleVersionNumber}' (Length: {bundleVersionNumber.Length})");
             PlayerSettings.bundleVersion = bundleVersionNumber;
         }
 
+        if (buildTarget == BuildTarget.iOS) {
+            HandleIosBuildNumber();
+        }
+
         if (buildTarget == BuildTarget.Android) {
             HandleAndroidAppBundle();
             HandleAndroidBundleVersionCode();
             HandleAndroidKeystore();
+            HandleAndroidTextureCompression();
+        }
+
+        if (buildTarget == BuildTarget.WebGL) {
+            HandleWebGLCompression();
         }
 
+        HandleServerBuild(buildTarget);
+        HandleArchitecture(buildTarget);
+        HandlePlayerSettings();
+        HandleDeterministic();
+
         var buildPath      = GetBuildPath();
         var buildName      = GetBuildName();
         var buildOptions   = GetBuildOptions();
         var fixedBuildPath = GetFixedBuildPath(buildTarget, buildPath, buildName);
 
         SetScriptingBackendFromEnv(buildTarget);
+        HandleIl2cppArgs();
+        HandleScopedDefines();
 
         var buildReport = BuildPipeline.BuildPlayer(GetEnabledScenes(), fixedBuildPath, buildTarget, buildOptions);
 
+        WriteBuildReport(buildReport, buildPath);
+
         if (buildReport.summary.result != UnityEditor.Build.Reporting.BuildResult.Succeeded)
             throw new Exception($"Build ended with {buildReport.summary.result} status");
 
         Console.WriteLine(":: Done with build");
     }
 
+    static void BuildAssetBundles()
+    {
+        var buildTarget = GetBuildTarget();
+        var buildPath   = GetBuildPath();
+
+        Console.WriteLine($":: Building AssetBundles for {buildTarget}");
+
+        Directory.CreateDirectory(buildPath);
+
+        var options = BuildAssetBundleOptions.None;
+        if (IsDeterministic())
+            options |= BuildAssetBundleOptions.DeterministicAssetBundle;
+
+        var manifest = BuildPipeline.BuildAssetBundles(buildPath, options, buildTarget);
+        if (manifest == null)
+            throw new Exception("AssetBundle build failed");
+
+        Console.WriteLine($":: Built {manifest.GetAllAssetBundles().Length} AssetBundles to {buildPath}");
+    }
+
+    // Addressables is optional, so it is looked up by reflection to keep
+    // projects without the package compiling
+    private const string ADDRESSABLES_SETTINGS_TYPE = "UnityEditor.AddressableAssets.Settings.AddressableAssetSettings, Unity.Addressables.Editor";
+    private const string ADDRESSABLES_BUILD_PATH = "Library/com.unity.addressables/aa";
+
+    static void BuildAddressables()
+    {
+        var buildTarget = GetBuildTarget();
+        var buildPath   = GetBuildPath();
+
+        Console.WriteLine($":: Building Addressables content for {buildTarget}");
+
+        var settingsType = Type.GetType(ADDRESSABLES_SETTINGS_TYPE);
+        if (settingsType == null)
+            throw new Exception("Addressables build failed: com.unity.addressables is not installed");
+
+        var buildPlayerContent = settingsType
+            .GetMethods(BindingFlags.Public | BindingFlags.Static)
+            .First(m => m.Name == "BuildPlayerContent" && m.GetParameters().Length == 1);
+
+        var args = new object[] { null };
+        buildPlayerContent.Invoke(null, args);
+
+        var error = args[0]?.GetType().GetProperty("Error")?.GetValue(args[0]) as string;
+        if (!string.IsNullOrEmpty(error))
+            throw new Exception($"Addressables build failed: {error}");
+
+        if (Directory.Exists(ADDRESSABLES_BUILD_PATH)) {
+            var catalogPath = Path.Combine(buildPath, "addressables");
+            CopyDirectory(ADDRESSABLES_BUILD_PATH, catalogPath);
+            Console.WriteLine($":: Copied Addressables content to {catalogPath}");
+        }
+
+        Console.WriteLine(":: Done with Addressables build");
+    }
+
+    static void CopyDirectory(string source, string destination)
+    {
+        Directory.CreateDirectory(destination);
+
+        foreach (var file in Directory.GetFiles(source))
+            File.Copy(file, Path.Combine(destination, Path.GetFileName(file)), true);
+
+        foreach (var dir in Directory.GetDirectories(source))
+            CopyDirectory(dir, Path.Combine(destination, Path.GetFileName(dir)));
+    }
+
+    [Serializable]
+    class BuildReportAsset
+    {
+        public string path;
+        public long size;
+    }
+
+    [Serializable]
+    class BuildReportSummary
+    {
+        public string result;
+        public long totalSize;
+        public double totalTime;
+        public BuildReportAsset[] assets;
+    }
+
+    // A failing report must not hide the build result, so it falls back to an
+    // empty object and a warning
+    private static void WriteBuildReport(UnityEditor.Build.Reporting.BuildReport buildReport, string buildPath)
+    {
+        var reportPath = Path.Combine(buildPath, "build-report.json");
+
+        try
+        {
+            File.WriteAllText(reportPath, UnityEngine.JsonUtility.ToJson(GetBuildReportSummary(buildReport), true));
+            Console.WriteLine($":: Wrote build report to {reportPath}");
+        }
+        catch (Exception e)
+        {
+            Console.WriteLine($":: Warning: could not write the build report, writing an empty one: {e.Message}");
+
+            try
+            {
+                File.WriteAllText(reportPath, "{}");
+            }
+            catch (Exception)
+            {
+                // Dirk writes the empty report itself when none exists
+            }
+        }
+    }
+
+    private static BuildReportSummary GetBuildReportSummary(UnityEditor.Build.Reporting.BuildReport buildReport)
+    {
+        var assets = (
+            from packed in buildReport.packedAssets
+            from content in packed.contents
+            group content by content.sourceAssetPath into asset
+            select new BuildReportAsset
+            {
+                path = asset.Key,
+                size = asset.Sum(a => (long)a.packedSize)
+            }
+        ).OrderByDescending(a => a.size).ToArray();
+
+        return new BuildReportSummary
+        {
+            result    = buildReport.summary.result.ToString(),
+            totalSize = (long)buildReport.summary.totalSize,
+            totalTime = buildReport.summary.totalTime.TotalSeconds,
+            assets    = assets
+        };
+    }
+
+    private static void HandleScopedDefines()
+    {
+        if (!TryGetEnv(SCOPED_DEFINES, out string value))
+            return;
+
+#if UNITY_2021_2_OR_NEWER
+        foreach (var entry in value.Split(';'))
+        {
+            var (namedBuildTarget, symbols) = ParseScopedDefine(entry);
+            var defines = PlayerSettings.GetScriptingDefineSymbols(namedBuildTarget);
+
+            defines = string.IsNullOrEmpty(defines) ? symbols : defines + ";" + symbols;
+
+            Console.WriteLine($":: Setting scripting defines for {namedBuildTarget.TargetName} to {defines}");
+            PlayerSettings.SetScriptingDefineSymbols(namedBuildTarget, defines);
+        }
+#else
+        throw new Exception($"{SCOPED_DEFINES} requires Unity 2021.2 or later");
+#endif
+    }
+
+#if UNITY_2021_2_OR_NEWER
+    // Entries look like Server:ENABLE_SERVER, where the prefix names a public
+    // static field of NamedBuildTarget such as Standalone, Server or Android
+    internal static (UnityEditor.Build.NamedBuildTarget, string) ParseScopedDefine(string entry)
+    {
+        var parts = entry.Split(new[] { ':' }, 2);
+
+        if (parts.Length != 2 || parts[0] == "" || parts[1] == "")
+            throw new Exception($"Invalid entry '{entry}' in {SCOPED_DEFINES}, expected <NamedBuildTarget>:<SYMBOL>");
+
+        var target = typeof(UnityEditor.Build.NamedBuildTarget).GetField(parts[0], BindingFlags.Public | BindingFlags.Static);
+
+        if (target == null || target.FieldType != typeof(UnityEditor.Build.NamedBuildTarget))
+            throw new Exception($"Unknown NamedBuildTarget '{parts[0]}' in {SCOPED_DEFINES}");
+
+        return ((UnityEditor.Build.NamedBuildTarget)target.GetValue(null), parts[1]);
+    }
+#endif
+
+    private static void HandleIl2cppArgs()
+    {
+        if (!TryGetEnv(IL2CPP_ARGS, out string value))
+            return;
+
+        Console.WriteLine($":: Setting additional IL2CPP arguments to {value}");
+        PlayerSettings.SetAdditionalIl2CppArgs(value);
+    }
+
+    static bool IsDeterministic()
+    {
+        return TryGetEnv(DETERMINISTIC, out string value) && bool.TryParse(value, out bool deterministic) && deterministic;
+    }
+
+    private static void HandleDeterministic()
+    {
+        if (!IsDeterministic())
+            return;
+
+#if UNITY_2020_2_OR_NEWER
+        Console.WriteLine(":: Enabling deterministic compilation");
+        PlayerSettings.SetUseDeterministicCompilation(true);
+#else
+        Console.WriteLine(":: Deterministic compilation requires Unity 2020.2 or later, skipping");
+#endif
+    }
+
+    // Each standalone platform keeps its architecture in its own
+    // UserBuildSettings class, looked up by reflection as the classes and
+    // their enum differ between Unity versions
+    private static void HandleArchitecture(BuildTarget buildTarget)
+    {
+        if (!TryGetEnv(ARCHITECTURE, out string value))
+            return;
+
+        string platform;
+        if (buildTarget == BuildTarget.StandaloneOSX)
+            platform = "OSXStandalone";
+        else if (buildTarget == BuildTarget.StandaloneWindows64)
+            platform = "WindowsStandalone";
+        else if (buildTarget == BuildTarget.StandaloneLinux64)
+            platform = "LinuxStandalone";
+        else
+            throw new Exception($"Choosing the architecture is not supported for {buildTarget}");
+
+        var typeName = $"UnityEditor.{platform}.UserBuildSettings";
+        var property = AppDomain.CurrentDomain.GetAssemblies()
+            .Select(assembly => assembly.GetType(typeName))
+            .Where(type => type != null)
+            .Select(type => type.GetProperty("architecture", BindingFlags.Public | BindingFlags.Static))
+            .FirstOrDefault(p => p != null);
+
+        if (property == null)
+            throw new Exception($"{typeName}.architecture not found, this Unity version can't choose the {buildTarget} architecture");
+
+        Console.WriteLine($":: Setting the {buildTarget} architecture to {value}");
+        property.SetValue(null, Enum.Parse(property.PropertyType, value));
+    }
+
+    private static void HandleServerBuild(BuildTarget buildTarget)
+    {
+        if (!TryGetEnv(BUILD_SERVER, out string value) || !bool.TryParse(value, out bool server) || !server)
+            return;
+
+#if UNITY_2021_2_OR_NEWER
+        if (BuildPipeline.GetBuildTargetGroup(buildTarget) != BuildTargetGroup.Standalone)
+            throw new Exception($"Server builds require a standalone build target, got {buildTarget}");
+
+        Console.WriteLine(":: Building the Dedicated Server subtarget");
+        EditorUserBuildSettings.standaloneBuildSubtarget = StandaloneBuildSubtarget.Server;
+#else
+        throw new Exception("Server builds require Unity 2021.2 or later");
+#endif
+    }
+
+    // Overrides read from the JSON file at PLAYER_SETTINGS. Fields left out
+    // keep the project's settings.
+    [Serializable]
+    private class PlayerSettingsOverrides
+    {
+        public string companyName;
+        public string productName;
+        public string icon;
+        public string orientation;
+    }
+
+    private static void HandlePlayerSettings()
+    {
+        if (!TryGetEnv(PLAYER_SETTINGS, out string path))
+            return;
+
+        var overrides = UnityEngine.JsonUtility.FromJson<PlayerSettingsOverrides>(File.ReadAllText(path));
+
+        if (!string.IsNullOrEmpty(overrides.companyName))
+        {
+            Console.WriteLine($":: Setting companyName to {overrides.companyName}");
+            PlayerSettings.companyName = overrides.companyName;
+        }
+
+        if (!string.IsNullOrEmpty(overrides.productName))
+        {
+            Console.WriteLine($":: Setting productName to {overrides.productName}");
+            PlayerSettings.productName = overrides.productName;
+        }
+
+        if (!string.IsNullOrEmpty(overrides.icon))
+        {
+            var texture = AssetDatabase.LoadAssetAtPath<UnityEngine.Texture2D>(overrides.icon);
+            if (texture == null)
+                throw new Exception($"{PLAYER_SETTINGS} icon \"{overrides.icon}\" is not a texture asset");
+
+            Console.WriteLine($":: Setting the default icon to {overrides.icon}");
+            PlayerSettings.SetIconsForTargetGroup(BuildTargetGroup.Unknown, new[] { texture });
+        }
+
+        if (!string.IsNullOrEmpty(overrides.orientation))
+        {
+            if (!overrides.orientation.TryConvertToEnum(out UIOrientation orientation))
+                throw new Exception($"{PLAYER_SETTINGS} orientation \"{overrides.orientation}\" is not a UIOrientation");
+
+            Console.WriteLine($":: Setting defaultInterfaceOrientation to {orientation}");
+            PlayerSettings.defaultInterfaceOrientation = orientation;
+        }
+    }
+
+    private static void HandleWebGLCompression()
+    {
+        if (!TryGetEnv(WEBGL_COMPRESSION, out string value))
+            return;
+
+        switch (value.ToLower())
+        {
+            case "gzip":
+                PlayerSettings.WebGL.compressionFormat = WebGLCompressionFormat.Gzip;
+                break;
+            case "brotli":
+                PlayerSettings.WebGL.compressionFormat = WebGLCompressionFormat.Brotli;
+                break;
+            case "disabled":
+                PlayerSettings.WebGL.compressionFormat = WebGLCompressionFormat.Disabled;
+                break;
+            default:
+                throw new Exception($"{WEBGL_COMPRESSION} \"{value}\" is not one of gzip, brotli or disabled");
+        }
+
+        Console.WriteLine($":: {WEBGL_COMPRESSION} env var detected, set compressionFormat to {PlayerSettings.WebGL.compressionFormat}.");
+    }
+
     private static void HandleAndroidAppBundle()
     {
         if (TryGetEnv(ANDROID_APP_BUNDLE, out string value))
@@ -217,6 +581,18 @@ static class BuildCommand
         }
     }
 
+    private static void HandleAndroidTextureCompression()
+    {
+        if (!TryGetEnv(TEXTURE_COMPRESSION, out string value))
+            return;
+
+        if (!value.TryConvertToEnum(out MobileTextureSubtarget subtarget))
+            throw new Exception($"{TEXTURE_COMPRESSION} \"{value}\" is not a MobileTextureSubtarget");
+
+        EditorUserBuildSettings.androidBuildSubtarget = subtarget;
+        Console.WriteLine($":: {TEXTURE_COMPRESSION} env var detected, set androidBuildSubtarget to {subtarget}.");
+    }
+
     private static void HandleAndroidBundleVersionCode()
     {
         if (TryGetEnv(ANDROID_BUNDLE_VERSION_CODE, out string value))
@@ -231,20 +607,18 @@ static class BuildCommand
         }
     }
 
-    private static string GetIosVersion()
+    private static void HandleIosBuildNumber()
     {
         if (TryGetEnv(VERSION_iOS, out string value))
         {
             if (int.TryParse(value, out int version))
             {
-                Console.WriteLine($":: {VERSION_iOS} env var detected, set the version to {value}.");
-                return version.ToString();
+                PlayerSettings.iOS.buildNumber = version.ToString();
+                Console.WriteLine($":: {VERSION_iOS} env var detected, set the build number to {value}.");
             }
             else
                 Console.WriteLine($":: {VERSION_iOS} env var detected but the version value \"{value}\" is not an integer.");
         }
-
-        throw new ArgumentNullException(nameof(value), $":: Error finding {VERSION_iOS} env var");
     }
 
     private static void HandleAndroidKeystore()
@@ -0,0 +1,30 @@
+#if UNITY_2021_2_OR_NEWER
+using NUnit.Framework;
+using System;
+using UnityEditor.Build;
+
+public class BuildCommandTests
+{
+	[Test]
+	public void ParseScopedDefineResolvesNamedBuildTargets ()
+	{
+		var (server, symbols) = BuildCommand.ParseScopedDefine("Server:ENABLE_SERVER");
+
+		Assert.AreEqual(NamedBuildTarget.Server, server);
+		Assert.AreEqual("ENABLE_SERVER", symbols);
+
+		var (android, _) = BuildCommand.ParseScopedDefine("Android:MOBILE");
+
+		Assert.AreEqual(NamedBuildTarget.Android, android);
+	}
+
+	[TestCase("ENABLE_SERVER")]
+	[TestCase("Server:")]
+	[TestCase(":ENABLE_SERVER")]
+	[TestCase("NoSuchTarget:ENABLE_SERVER")]
+	public void ParseScopedDefineRejectsInvalidEntries (string entry)
+	{
+		Assert.Throws<Exception>(() => BuildCommand.ParseScopedDefine(entry));
+	}
+}
+#endif